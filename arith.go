@@ -0,0 +1,265 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+	"strconv"
+)
+
+// Arithmetic in templates follows these promotion rules:
+//
+//   - signed op signed is computed as int64
+//   - unsigned op unsigned is computed as uint64
+//   - signed op unsigned is computed as int64; the unsigned operand has to fit in an int64
+//   - if either operand is a float, both are converted and the operation is computed as float64
+//   - operands of math/big types are computed exactly, see bigArithmetic
+//
+// Number literals evaluate to float64 values, so integer arithmetic only applies to Go integer values.
+// Integer operations which overflow their result type and divisions (or modulo) by zero are errors.
+// Integer division truncates towards zero, and modulo truncates float operands to integers.
+
+var errDivisionByZero = errors.New("division by zero")
+
+type numberClass int
+
+const (
+	notANumber numberClass = iota
+	signedNumber
+	unsignedNumber
+	floatNumber
+	bigIntNumber   // *big.Int
+	bigRatNumber   // *big.Rat or Rational
+	bigFloatNumber // *big.Float
+)
+
+func classifyNumber(v reflect.Value) numberClass {
+	switch kind := v.Kind(); {
+	case isInt(kind):
+		return signedNumber
+	case isUint(kind):
+		return unsignedNumber
+	case isFloat(kind):
+		return floatNumber
+	case kind == reflect.Ptr || kind == reflect.Struct:
+		return classifyBigNumber(v)
+	}
+	return notANumber
+}
+
+// parseNumber converts a string operand to the narrowest number class able to represent it.
+func parseNumber(s string) (reflect.Value, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return reflect.ValueOf(i), nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return reflect.ValueOf(u), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("%q is not a number", s)
+	}
+	return reflect.ValueOf(f), nil
+}
+
+// arithmetic computes left op right; both operands have to be numbers.
+func arithmetic(op item, left, right reflect.Value) (reflect.Value, error) {
+	lc, rc := classifyNumber(left), classifyNumber(right)
+	switch {
+	case lc >= bigIntNumber || rc >= bigIntNumber:
+		return bigArithmetic(op, left, lc, right, rc)
+	case lc == floatNumber || rc == floatNumber:
+		return floatArithmetic(op, toFloat(left), toFloat(right))
+	case lc == signedNumber && rc == signedNumber:
+		return intArithmetic(op, left.Int(), right.Int())
+	case lc == unsignedNumber && rc == unsignedNumber:
+		return uintArithmetic(op, left.Uint(), right.Uint())
+	}
+	l, err := signedOperand(left)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	r, err := signedOperand(right)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return intArithmetic(op, l, r)
+}
+
+// signedOperand returns the value of an integer operand used in mixed signed and unsigned arithmetic.
+func signedOperand(v reflect.Value) (int64, error) {
+	if isInt(v.Kind()) {
+		return v.Int(), nil
+	}
+	u := v.Uint()
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("integer overflow: %d overflows int64 in arithmetic with a signed integer", u)
+	}
+	return int64(u), nil
+}
+
+func intArithmetic(op item, a, b int64) (reflect.Value, error) {
+	var r int64
+	overflow := false
+	switch op.typ {
+	case itemAdd:
+		r = a + b
+		overflow = (b > 0 && r < a) || (b < 0 && r > a)
+	case itemMinus:
+		r = a - b
+		overflow = (b > 0 && r > a) || (b < 0 && r < a)
+	case itemMul:
+		r = a * b
+		overflow = a != 0 && (r/a != b || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64))
+	case itemDiv, itemMod:
+		if b == 0 {
+			return reflect.Value{}, errDivisionByZero
+		}
+		if op.typ == itemMod {
+			r = a % b
+			break
+		}
+		r = a / b
+		overflow = a == math.MinInt64 && b == -1
+	}
+	if overflow {
+		return reflect.Value{}, fmt.Errorf("integer overflow: %d %s %d overflows int64", a, op.val, b)
+	}
+	return reflect.ValueOf(r), nil
+}
+
+func uintArithmetic(op item, a, b uint64) (reflect.Value, error) {
+	var r uint64
+	overflow := false
+	switch op.typ {
+	case itemAdd:
+		var carry uint64
+		r, carry = bits.Add64(a, b, 0)
+		overflow = carry != 0
+	case itemMinus:
+		r = a - b
+		overflow = b > a
+	case itemMul:
+		var hi uint64
+		hi, r = bits.Mul64(a, b)
+		overflow = hi != 0
+	case itemDiv, itemMod:
+		if b == 0 {
+			return reflect.Value{}, errDivisionByZero
+		}
+		if op.typ == itemMod {
+			r = a % b
+		} else {
+			r = a / b
+		}
+	}
+	if overflow {
+		return reflect.Value{}, fmt.Errorf("integer overflow: %d %s %d overflows uint64", a, op.val, b)
+	}
+	return reflect.ValueOf(r), nil
+}
+
+func floatArithmetic(op item, a, b float64) (reflect.Value, error) {
+	switch op.typ {
+	case itemAdd:
+		return reflect.ValueOf(a + b), nil
+	case itemMinus:
+		return reflect.ValueOf(a - b), nil
+	case itemMul:
+		return reflect.ValueOf(a * b), nil
+	}
+	if op.typ == itemMod {
+		// like in previous versions, modulo is an integer operation: 10 / 3 % 1 is 0
+		return intArithmetic(op, int64(a), int64(b))
+	}
+	if b == 0 {
+		return reflect.Value{}, errDivisionByZero
+	}
+	return reflect.ValueOf(a / b), nil
+}
+
+// negate computes -v; v has to be a number.
+func negate(v reflect.Value) (reflect.Value, error) {
+	switch classifyNumber(v) {
+	case signedNumber:
+		if i := v.Int(); i != math.MinInt64 {
+			return reflect.ValueOf(-i), nil
+		}
+		return reflect.Value{}, fmt.Errorf("integer overflow: -(%d) overflows int64", v.Int())
+	case unsignedNumber:
+		if u := v.Uint(); u <= math.MaxInt64+1 {
+			return reflect.ValueOf(-int64(u)), nil
+		}
+		return reflect.Value{}, fmt.Errorf("integer overflow: -%d overflows int64", v.Uint())
+	case floatNumber:
+		return reflect.ValueOf(-v.Float()), nil
+	}
+	return bigNegate(v)
+}
+
+// compareNumbers reports whether left op right holds for the numeric comparison op, comparing integers
+// exactly; both operands have to be numbers.
+func compareNumbers(op itemType, left, right reflect.Value) (bool, error) {
+	lc, rc := classifyNumber(left), classifyNumber(right)
+	switch {
+	case lc >= bigIntNumber || rc >= bigIntNumber:
+		c, err := bigCompare(left, lc, right, rc)
+		if err != nil {
+			return false, err
+		}
+		return holds(op, c < 0, c == 0, c > 0), nil
+	case lc == floatNumber || rc == floatNumber:
+		a, b := toFloat(left), toFloat(right)
+		return holds(op, a < b, a == b, a > b), nil
+	case lc == signedNumber && rc == signedNumber:
+		a, b := left.Int(), right.Int()
+		return holds(op, a < b, a == b, a > b), nil
+	case lc == unsignedNumber && rc == unsignedNumber:
+		a, b := left.Uint(), right.Uint()
+		return holds(op, a < b, a == b, a > b), nil
+	case lc == signedNumber:
+		if left.Int() < 0 {
+			return holds(op, true, false, false), nil
+		}
+		a, b := uint64(left.Int()), right.Uint()
+		return holds(op, a < b, a == b, a > b), nil
+	default:
+		if right.Int() < 0 {
+			return holds(op, false, false, true), nil
+		}
+		a, b := left.Uint(), uint64(right.Int())
+		return holds(op, a < b, a == b, a > b), nil
+	}
+}
+
+func holds(op itemType, less, equal, greater bool) bool {
+	switch op {
+	case itemGreat:
+		return greater
+	case itemGreatEquals:
+		return greater || equal
+	case itemLess:
+		return less
+	case itemLessEquals:
+		return less || equal
+	case itemEquals:
+		return equal
+	}
+	return false
+}
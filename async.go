@@ -0,0 +1,158 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+)
+
+// asyncSegment is a piece of output produced while rendering a template containing
+// asynchronous includes. Segments are flushed in document order once the template
+// is done executing.
+type asyncSegment struct {
+	buf  *bytes.Buffer
+	done chan struct{} // nil for segments written by the calling goroutine
+	err  interface{}   // value recovered from a panicking async include
+}
+
+// asyncOutput tracks the segments written since the first async include of a template.
+type asyncOutput struct {
+	writer   io.Writer // writer the template was rendering to before the first async include
+	segments []*asyncSegment
+}
+
+// fork returns a deep copy of the scope chain, so that a goroutine can read
+// and write variables without racing with the runtime it was forked from.
+func (s *scope) fork() *scope {
+	if s == nil {
+		return nil
+	}
+	fork := &scope{parent: s.parent.fork(), layout: s.layout, blocks: s.blocks}
+	fork.slots = fork.allocSlots(len(s.slots))
+	copy(fork.slots, s.slots)
+	if s.variables != nil {
+		fork.variables = make(VarMap, len(s.variables))
+		for name, value := range s.variables {
+			fork.variables[name] = value
+		}
+	}
+	return fork
+}
+
+// fork returns a new runtime rendering into w, with a copy of the variables,
+// blocks, content, loop and context of st.
+func (st *Runtime) fork(w io.Writer) *Runtime {
+	if st.execution == nil {
+		// lambdas created by the fork are invalidated together with the ones of st
+		st.execution = &execution{}
+	}
+	return &Runtime{
+		escapeeWriter: &escapeeWriter{Writer: w, set: st.set},
+		scope:         st.scope.fork(),
+		content:       st.content,
+		includeDepth:  st.includeDepth,
+		globals:       st.globals,
+		formatters:    st.formatters,
+		currentLoop:   st.currentLoop.snapshot(),
+		execution:     st.execution,
+		context:       st.context,
+	}
+}
+
+// executeListFlushing executes list, the root of a template or a list whose output is captured, writing the output
+// of the async includes executed meanwhile (nested in other statements or included templates too) to the current
+// writer once the list is done.
+func (st *Runtime) executeListFlushing(list *ListNode) reflect.Value {
+	outer := st.async
+	st.async = nil
+	defer func() {
+		if st.async != nil {
+			st.async.restore(st)
+		}
+		st.async = outer
+	}()
+	returnValue := st.executeList(list)
+	if st.async != nil {
+		st.async.flush(st)
+	}
+	return returnValue
+}
+
+// executeAsyncInclude starts rendering the included template in a new goroutine and redirects
+// the output of st to a new segment, which will be written after the included template's output.
+func (st *Runtime) executeAsyncInclude(node *IncludeNode) {
+	if st.includeDepth >= 100_000 {
+		node.errorf("maximum 'include' depth (100000) exceeded")
+	}
+
+	// name and context are evaluated synchronously, so errors point at the include and
+	// expressions are evaluated in document order
+	t := st.resolveIncludeTemplate(node)
+	context := st.context
+	if node.Context != nil {
+		context = st.evalPrimaryExpressionGroup(node.Context)
+	}
+
+	if st.async == nil {
+		st.async = &asyncOutput{writer: st.Writer}
+	}
+	included := &asyncSegment{buf: new(bytes.Buffer), done: make(chan struct{})}
+	fork := st.fork(included.buf)
+	fork.includeDepth++
+	fork.context = context
+	fork.newScope(nil)
+	fork.blocks = t.processedBlocks
+
+	Root := t.Root
+	for t.extends != nil {
+		t = t.extends
+		Root = t.Root
+	}
+
+	go func() {
+		defer close(included.done)
+		defer func() { included.err = recover() }()
+		fork.executeListFlushing(Root)
+	}()
+
+	next := &asyncSegment{buf: new(bytes.Buffer)}
+	st.async.segments = append(st.async.segments, included, next)
+	st.Writer = next.buf
+}
+
+// restore makes st write to the original writer again, discarding pending segments.
+func (out *asyncOutput) restore(st *Runtime) {
+	st.Writer = out.writer
+}
+
+// flush restores the original writer of st and writes all segments to it in order, waiting
+// for async includes to finish. The first error raised by an async include is re-panicked,
+// after the output preceding it was written.
+func (out *asyncOutput) flush(st *Runtime) {
+	st.Writer = out.writer
+	for _, segment := range out.segments {
+		if segment.done != nil {
+			<-segment.done
+			if segment.err != nil {
+				panic(segment.err)
+			}
+		}
+		if _, err := segment.buf.WriteTo(out.writer); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Rational is implemented by exact numeric types which aren't part of math/big, like decimals, so they can be
+// used in arithmetic and comparisons in templates: they are computed as *big.Rat values.
+// For example, github.com/shopspring/decimal.Decimal implements Rational.
+type Rational interface {
+	Rat() *big.Rat
+}
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigRatType   = reflect.TypeOf(big.Rat{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+	rationalType = reflect.TypeOf((*Rational)(nil)).Elem()
+)
+
+// classifyBigNumber classifies values of the math/big number types (or pointers to them) and Rational values.
+func classifyBigNumber(v reflect.Value) numberClass {
+	typ := v.Type()
+	if typ.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return notANumber
+		}
+		typ = typ.Elem()
+	}
+	switch typ {
+	case bigIntType:
+		return bigIntNumber
+	case bigRatType:
+		return bigRatNumber
+	case bigFloatType:
+		return bigFloatNumber
+	}
+	if v.Type().Implements(rationalType) {
+		return bigRatNumber
+	}
+	return notANumber
+}
+
+// bigPointer returns v, a math/big number or a pointer to one, as a pointer.
+func bigPointer(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		return v
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
+
+func toBigInt(v reflect.Value, class numberClass) *big.Int {
+	switch class {
+	case signedNumber:
+		return big.NewInt(v.Int())
+	case unsignedNumber:
+		return new(big.Int).SetUint64(v.Uint())
+	}
+	return bigPointer(v).Interface().(*big.Int)
+}
+
+// toBigRat converts v to a *big.Rat. Floats are converted using the shortest decimal representation which
+// identifies them, so 0.1 becomes exactly 1/10.
+func toBigRat(v reflect.Value, class numberClass) (*big.Rat, error) {
+	switch class {
+	case signedNumber, unsignedNumber, bigIntNumber:
+		return new(big.Rat).SetInt(toBigInt(v, class)), nil
+	case floatNumber:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%v can't be converted to *big.Rat", f)
+		}
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, v.Type().Bits()))
+		return r, nil
+	case bigFloatNumber:
+		f := bigPointer(v).Interface().(*big.Float)
+		if f.IsInf() {
+			return nil, fmt.Errorf("%v can't be converted to *big.Rat", f)
+		}
+		r, _ := f.Rat(nil)
+		return r, nil
+	}
+	if r, ok := v.Interface().(Rational); ok {
+		if rat := r.Rat(); rat != nil {
+			return rat, nil
+		}
+		return nil, fmt.Errorf("%s has no rational value", v.Type())
+	}
+	return bigPointer(v).Interface().(*big.Rat), nil
+}
+
+func toBigFloat(v reflect.Value, class numberClass) (*big.Float, error) {
+	switch class {
+	case signedNumber:
+		return new(big.Float).SetInt64(v.Int()), nil
+	case unsignedNumber:
+		return new(big.Float).SetUint64(v.Uint()), nil
+	case floatNumber:
+		if f := v.Float(); !math.IsNaN(f) {
+			return new(big.Float).SetFloat64(f), nil
+		}
+		return nil, fmt.Errorf("NaN can't be converted to *big.Float")
+	case bigIntNumber:
+		return new(big.Float).SetInt(toBigInt(v, class)), nil
+	case bigRatNumber:
+		r, err := toBigRat(v, class)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Float).SetRat(r), nil
+	}
+	return bigPointer(v).Interface().(*big.Float), nil
+}
+
+// bigArithmetic computes left op right when at least one of the operands is a math/big number or a Rational,
+// without modifying the operands. The result is:
+//
+//   - a *big.Float if either operand is a *big.Float, with the larger precision of both operands
+//   - a *big.Rat if either operand is a *big.Rat, a Rational or a float
+//   - a *big.Int otherwise; division truncates and % is the remainder, like for Go integers
+//
+// Floats with an integral value are used as integers with *big.Int operands, so big + 1 is a *big.Int. Dividing
+// by or into such a float is still exact, though: the result is a *big.Rat unless it's an integer.
+//
+// % is only defined for integers.
+func bigArithmetic(op item, left reflect.Value, lc numberClass, right reflect.Value, rc numberClass) (reflect.Value, error) {
+	fromFloat := lc == floatNumber || rc == floatNumber
+	if lc == bigIntNumber {
+		right, rc = integralOperand(right, rc)
+	} else if rc == bigIntNumber {
+		left, lc = integralOperand(left, lc)
+	}
+	switch {
+	case lc == bigFloatNumber || rc == bigFloatNumber:
+		x, err := toBigFloat(left, lc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		y, err := toBigFloat(right, rc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		prec := x.Prec()
+		if y.Prec() > prec {
+			prec = y.Prec()
+		}
+		z := new(big.Float).SetPrec(prec)
+		switch op.typ {
+		case itemAdd:
+			return reflect.ValueOf(z.Add(x, y)), nil
+		case itemMinus:
+			return reflect.ValueOf(z.Sub(x, y)), nil
+		case itemMul:
+			return reflect.ValueOf(z.Mul(x, y)), nil
+		case itemDiv:
+			if y.Sign() == 0 {
+				return reflect.Value{}, errDivisionByZero
+			}
+			return reflect.ValueOf(z.Quo(x, y)), nil
+		}
+		return reflect.Value{}, fmt.Errorf("operator %s is not defined for *big.Float", op.val)
+	case lc == bigRatNumber || rc == bigRatNumber || lc == floatNumber || rc == floatNumber:
+		x, err := toBigRat(left, lc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		y, err := toBigRat(right, rc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		z := new(big.Rat)
+		switch op.typ {
+		case itemAdd:
+			return reflect.ValueOf(z.Add(x, y)), nil
+		case itemMinus:
+			return reflect.ValueOf(z.Sub(x, y)), nil
+		case itemMul:
+			return reflect.ValueOf(z.Mul(x, y)), nil
+		case itemDiv:
+			if y.Sign() == 0 {
+				return reflect.Value{}, errDivisionByZero
+			}
+			return reflect.ValueOf(z.Quo(x, y)), nil
+		}
+		return reflect.Value{}, fmt.Errorf("operator %s is not defined for *big.Rat", op.val)
+	}
+	x, y := toBigInt(left, lc), toBigInt(right, rc)
+	z := new(big.Int)
+	switch op.typ {
+	case itemAdd:
+		z.Add(x, y)
+	case itemMinus:
+		z.Sub(x, y)
+	case itemMul:
+		z.Mul(x, y)
+	case itemDiv, itemMod:
+		if y.Sign() == 0 {
+			return reflect.Value{}, errDivisionByZero
+		}
+		if op.typ == itemMod {
+			z.Rem(x, y)
+		} else if _, m := z.QuoRem(x, y, new(big.Int)); fromFloat && m.Sign() != 0 {
+			return reflect.ValueOf(new(big.Rat).SetFrac(x, y)), nil
+		}
+	}
+	return reflect.ValueOf(z), nil
+}
+
+// bigNegate computes -v for a math/big number or a Rational.
+func bigNegate(v reflect.Value) (reflect.Value, error) {
+	switch class := classifyNumber(v); class {
+	case bigIntNumber:
+		return reflect.ValueOf(new(big.Int).Neg(toBigInt(v, class))), nil
+	case bigFloatNumber:
+		f, _ := toBigFloat(v, class)
+		return reflect.ValueOf(new(big.Float).Neg(f)), nil
+	default:
+		r, err := toBigRat(v, class)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(new(big.Rat).Neg(r)), nil
+	}
+}
+
+// bigCompare returns -1, 0 or +1 depending on whether left is less than, equal to or greater than right, when
+// at least one of the operands is a math/big number or a Rational. Operands are converted like in bigArithmetic.
+func bigCompare(left reflect.Value, lc numberClass, right reflect.Value, rc numberClass) (int, error) {
+	switch {
+	case lc == bigFloatNumber || rc == bigFloatNumber:
+		x, err := toBigFloat(left, lc)
+		if err != nil {
+			return 0, err
+		}
+		y, err := toBigFloat(right, rc)
+		if err != nil {
+			return 0, err
+		}
+		return x.Cmp(y), nil
+	case lc == bigRatNumber || rc == bigRatNumber || lc == floatNumber || rc == floatNumber:
+		x, err := toBigRat(left, lc)
+		if err != nil {
+			return 0, err
+		}
+		y, err := toBigRat(right, rc)
+		if err != nil {
+			return 0, err
+		}
+		return x.Cmp(y), nil
+	}
+	return toBigInt(left, lc).Cmp(toBigInt(right, rc)), nil
+}
+
+// integralOperand converts a float operand with an integral value to a *big.Int, so that number literals (which
+// are float64 values) keep arithmetic with *big.Int values exact: big + 1 is a *big.Int.
+func integralOperand(v reflect.Value, class numberClass) (reflect.Value, numberClass) {
+	if class != floatNumber {
+		return v, class
+	}
+	f := v.Float()
+	if math.IsInf(f, 0) || math.Trunc(f) != f {
+		return v, class
+	}
+	i, _ := new(big.Float).SetFloat64(f).Int(nil)
+	return reflect.ValueOf(i), bigIntNumber
+}
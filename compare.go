@@ -0,0 +1,181 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// comparisonMethod looks up the method name of v (or of &v, if v is addressable) taking a single argument other
+// can be passed as, and returning a single value of kind result. It returns the method and the argument to call
+// it with.
+func comparisonMethod(v reflect.Value, name string, other reflect.Value, result reflect.Kind) (method, arg reflect.Value, ok bool) {
+	if !v.CanInterface() || !other.CanInterface() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return
+	}
+	if v.Type().NumMethod() == 0 && !v.CanAddr() {
+		return
+	}
+	index, found := methodIndex(v.Type(), name)
+	if !found && v.CanAddr() {
+		v = v.Addr()
+		index, found = methodIndex(v.Type(), name)
+	}
+	if !found {
+		return
+	}
+	method = v.Method(index)
+	typ := method.Type()
+	if typ.NumIn() != 1 || typ.NumOut() != 1 || typ.Out(0).Kind() != result {
+		return
+	}
+	switch in := typ.In(0); {
+	case other.Type().AssignableTo(in):
+		arg = other
+	case other.Kind() == reflect.Ptr && !other.IsNil() && other.Type().Elem().AssignableTo(in):
+		arg = other.Elem()
+	case other.CanAddr() && other.Addr().Type().AssignableTo(in):
+		arg = other.Addr()
+	default:
+		return
+	}
+	return method, arg, true
+}
+
+// compareOrdered compares values which aren't numbers: time.Time values and values with a Compare method
+// accepting the other value and returning an int. It returns -1, 0 or +1 depending on whether left is less
+// than, equal to or greater than right, and false if the values can't be compared.
+func compareOrdered(left, right reflect.Value) (int, bool) {
+	if left.Type() == timeType && right.Type() == timeType && left.CanInterface() && right.CanInterface() {
+		l, r := left.Interface().(time.Time), right.Interface().(time.Time)
+		return order(l.Before(r), l.After(r)), true
+	}
+	if method, arg, ok := comparisonMethod(left, "Compare", right, reflect.Int); ok {
+		c := method.Call([]reflect.Value{arg})[0].Int()
+		return order(c < 0, c > 0), true
+	}
+	if method, arg, ok := comparisonMethod(right, "Compare", left, reflect.Int); ok {
+		c := method.Call([]reflect.Value{arg})[0].Int()
+		return order(c > 0, c < 0), true
+	}
+	return 0, false
+}
+
+// equalByMethod compares values using an Equal method accepting the other value and returning a bool, or else a
+// Compare method. It returns false as second value if neither value has such a method.
+func equalByMethod(v1, v2 reflect.Value) (equal, ok bool) {
+	if method, arg, ok := comparisonMethod(v1, "Equal", v2, reflect.Bool); ok {
+		return method.Call([]reflect.Value{arg})[0].Bool(), true
+	}
+	if method, arg, ok := comparisonMethod(v2, "Equal", v1, reflect.Bool); ok {
+		return method.Call([]reflect.Value{arg})[0].Bool(), true
+	}
+	if c, ok := compareOrdered(v1, v2); ok {
+		return c == 0, true
+	}
+	return false, false
+}
+
+func order(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// contains reports whether collection contains v: for strings, whether v is a substring, for slices and arrays,
+// whether v is equal to one of the elements, and for maps, whether v is a key. Nil collections contain nothing.
+func contains(collection, v reflect.Value) (bool, error) {
+	collection, isNil := indirect(collection)
+	if isNil || !collection.IsValid() {
+		return false, nil
+	}
+	v = indirectInterface(v)
+	switch collection.Kind() {
+	case reflect.String:
+		if !v.IsValid() || v.Kind() != reflect.String {
+			return false, fmt.Errorf("can't look for %s in a string", getTypeString(v))
+		}
+		return strings.Contains(collection.String(), v.String()), nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < collection.Len(); i++ {
+			if checkEquality(v, collection.Index(i)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		keyType := collection.Type().Key()
+		switch {
+		case v.IsValid() && v.Type().AssignableTo(keyType):
+		case v.Kind() == reflect.String && keyType.Kind() == reflect.String:
+			v = v.Convert(keyType)
+		case classifyNumber(v) != notANumber && classifyNumber(reflect.Zero(keyType)) != notANumber:
+			key, err := convertValue(v, keyType)
+			if err != nil || !checkEquality(v, key) {
+				// the number can't be represented exactly by the key type, so it can't be a key
+				return false, nil
+			}
+			v = key
+		default:
+			return false, fmt.Errorf("can't look for %s in the keys of %s", getTypeString(v), collection.Type())
+		}
+		return collection.MapIndex(v).IsValid(), nil
+	}
+	return false, fmt.Errorf("can't look for a value in %s: not a string, slice, array or map", getTypeString(collection))
+}
+
+var regexpType = reflect.TypeOf((*regexp.Regexp)(nil))
+
+// cachedPattern is the regular expression compiled for a '=~' expression.
+type cachedPattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// match reports whether the string v matches pattern, a string or a *regexp.Regexp. Patterns compiled from strings
+// are cached in node, so that a constant pattern is only compiled once.
+func (node *ComparativeExprNode) match(v, pattern reflect.Value) (bool, error) {
+	v, pattern = indirectInterface(v), indirectInterface(pattern)
+	if !v.IsValid() || v.Kind() != reflect.String {
+		return false, fmt.Errorf("can't match %s against a regular expression: not a string", getTypeString(v))
+	}
+	if pattern.IsValid() && pattern.Type() == regexpType && !pattern.IsNil() && pattern.CanInterface() {
+		return pattern.Interface().(*regexp.Regexp).MatchString(v.String()), nil
+	}
+	if !pattern.IsValid() || pattern.Kind() != reflect.String {
+		return false, fmt.Errorf("can't use %s as regular expression: not a string", getTypeString(pattern))
+	}
+	source := pattern.String()
+	cached, _ := node.pattern.Load().(*cachedPattern)
+	if cached == nil || cached.source != source {
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		cached = &cachedPattern{source: source, re: re}
+		node.pattern.Store(cached)
+	}
+	return cached.re.MatchString(v.String()), nil
+}
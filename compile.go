@@ -0,0 +1,541 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// evalFunc evaluates a compiled expression, or executes a compiled list.
+type evalFunc func(st *Runtime) reflect.Value
+
+// compiledNode executes a compiled node of a list. returns reports whether the result
+// replaces the return value of the list.
+type compiledNode struct {
+	exec    func(st *Runtime, ls *listState) reflect.Value
+	returns bool
+}
+
+// compile turns the parse tree of t into a tree of closures, so executing the template doesn't
+// have to dispatch on the type of every node again. Constant expressions are evaluated once and
+// field accesses cache the struct field index for the type they were last used with. Nodes without
+// compiled form keep being interpreted by executeList and evalPrimaryExpressionGroup.
+func (t *Template) compile() {
+	compileList(t.Root)
+	for _, block := range t.passedBlocks {
+		compileBlockNode(block)
+	}
+}
+
+func compileList(list *ListNode) {
+	if list == nil || list.exec != nil {
+		return
+	}
+
+	nodes := make([]compiledNode, len(list.Nodes))
+	frame := list.frame
+	for i, node := range list.Nodes {
+		nodes[i] = compileNode(node)
+	}
+
+	list.exec = func(st *Runtime) (returnValue reflect.Value) {
+		var ls *listState
+		if frame != nil {
+			ls = &listState{inNewScope: true}
+			defer ls.release(st)
+			st.newScope(frame)
+		}
+		for i := 0; i < len(nodes) && st.loop == loopNone; i++ {
+			if value := nodes[i].exec(st, ls); nodes[i].returns {
+				returnValue = value
+			}
+		}
+		return returnValue
+	}
+}
+
+func compileNode(node Node) compiledNode {
+	switch node := node.(type) {
+	case *TextNode:
+		return compiledNode{exec: func(st *Runtime, _ *listState) reflect.Value {
+			_, err := st.Writer.Write(node.Text)
+			if err != nil {
+				node.error(err)
+			}
+			return reflect.Value{}
+		}}
+	case *ActionNode:
+		return compileActionNode(node)
+	case *IfNode:
+		compileSetNode(node.Set)
+		compileExpr(node.Expression)
+		compileList(node.List)
+		compileList(node.ElseList)
+	case *WithNode:
+		compileSetNode(node.Set)
+		if node.Expression != nil {
+			compileExpr(node.Expression)
+		}
+		compileList(node.List)
+		compileList(node.ElseList)
+	case *RangeNode:
+		compileSetNode(node.Set)
+		if node.Expression != nil {
+			compileExpr(node.Expression)
+		}
+		for _, expression := range []Expression{node.To, node.Step, node.Where, node.Offset, node.Limit, node.Batch} {
+			if expression != nil {
+				compileExpr(expression)
+			}
+		}
+		compileList(node.List)
+		compileList(node.ElseList)
+	case *TryNode:
+		compileList(node.List)
+		if node.Catch != nil {
+			compileList(node.Catch.List)
+		}
+	case *SwitchNode:
+		compileSetNode(node.Set)
+		compileExpr(node.Expression)
+		for _, c := range node.Cases {
+			for _, expression := range c.Expressions {
+				compileExpr(expression)
+			}
+			compileList(c.List)
+		}
+		compileList(node.Default)
+	case *YieldNode:
+		compileBlockParameters(node.Parameters)
+		if node.Expression != nil {
+			compileExpr(node.Expression)
+		}
+		compileList(node.Content)
+	case *BlockNode:
+		compileBlockNode(node)
+	case *IncludeNode:
+		compileExpr(node.Name)
+		if node.Context != nil {
+			compileExpr(node.Context)
+		}
+	case *ReturnNode:
+		value := compileExpr(node.Value)
+		return compiledNode{exec: func(st *Runtime, _ *listState) reflect.Value { return value(st) }, returns: true}
+	}
+
+	// everything else runs through the same code as uncompiled nodes, using the compiled child nodes
+	return compiledNode{
+		exec: func(st *Runtime, ls *listState) reflect.Value {
+			value, _ := st.executeNode(node, ls)
+			return value
+		},
+		returns: node.Type() == NodeIf || node.Type() == NodeRange || node.Type() == NodeTry || node.Type() == NodeSwitch || node.Type() == NodeWith || node.Type() == NodeInclude,
+	}
+}
+
+func compileArgs(args CallArgs) {
+	for _, expr := range args.Exprs {
+		compileExpr(expr)
+	}
+	for _, named := range args.Named {
+		compileExpr(named.Expression)
+	}
+}
+
+func compileActionNode(node *ActionNode) compiledNode {
+	compileSetNode(node.Set)
+	if node.Pipe == nil {
+		return compiledNode{exec: func(st *Runtime, _ *listState) reflect.Value {
+			st.executeAction(node)
+			return reflect.Value{}
+		}}
+	}
+
+	for _, cmd := range node.Pipe.Cmds {
+		compileExpr(cmd.BaseExpr)
+		compileArgs(cmd.CallArgs)
+	}
+
+	if node.Set == nil && len(node.Pipe.Cmds) == 1 && node.Pipe.Cmds[0].Exprs == nil {
+		// the most common action: print the value of an expression
+		value := compileExpr(node.Pipe.Cmds[0].BaseExpr)
+		return compiledNode{exec: func(st *Runtime, _ *listState) reflect.Value {
+			st.printValue(node, value(st))
+			return reflect.Value{}
+		}}
+	}
+
+	return compiledNode{exec: func(st *Runtime, _ *listState) reflect.Value {
+		st.executeAction(node)
+		return reflect.Value{}
+	}}
+}
+
+func compileSetNode(set *SetNode) {
+	if set == nil {
+		return
+	}
+	for _, expr := range set.Right {
+		compileExpr(expr)
+	}
+}
+
+func compileBlockNode(block *BlockNode) {
+	compileBlockParameters(block.Parameters)
+	if block.Expression != nil {
+		compileExpr(block.Expression)
+	}
+	compileList(block.List)
+	compileList(block.Content)
+}
+
+func compileBlockParameters(params *BlockParameterList) {
+	if params == nil {
+		return
+	}
+	for _, param := range params.List {
+		if param.Expression != nil {
+			compileExpr(param.Expression)
+		}
+	}
+}
+
+// compileExpr compiles node and returns the resulting closure. Expressions without compiled
+// form are returned as a closure calling the interpreter.
+func compileExpr(node Expression) evalFunc {
+	if eval := node.compiled(); eval != nil {
+		return eval
+	}
+	eval, _ := compileExprConst(node)
+	return eval
+}
+
+// compileExprConst compiles node and reports whether it's a constant expression.
+func compileExprConst(node Expression) (eval evalFunc, constant bool) {
+	var base *NodeBase
+
+	switch node := node.(type) {
+	case *NilNode:
+		base, eval, constant = &node.NodeBase, constantEval(reflect.Value{}), true
+	case *BoolNode:
+		v := valueBoolFALSE
+		if node.True {
+			v = valueBoolTRUE
+		}
+		base, eval, constant = &node.NodeBase, constantEval(v), true
+	case *StringNode:
+		base, eval, constant = &node.NodeBase, constantEval(reflect.ValueOf(&node.Text).Elem()), true
+	case *NumberNode:
+		v := node.value()
+		if !v.IsValid() {
+			return interpreted(node), false
+		}
+		base, eval, constant = &node.NodeBase, constantEval(v), true
+	case *IdentifierNode:
+		base, eval = &node.NodeBase, func(st *Runtime) reflect.Value {
+			resolved, err := st.resolveVariable(node)
+			if err != nil {
+				node.error(err)
+			}
+			return resolved
+		}
+	case *FieldNode:
+		base, eval = &node.NodeBase, compileFieldNode(node)
+	case *ChainNode:
+		base, eval = &node.NodeBase, compileChainNode(node)
+	case *AdditiveExprNode:
+		base = &node.NodeBase
+		right, rightConst := compileExprConst(node.Right)
+		if node.Left == nil {
+			eval, constant = func(st *Runtime) reflect.Value {
+				return evalAdditive(node, reflect.Value{}, right(st))
+			}, rightConst
+			break
+		}
+		left, leftConst := compileExprConst(node.Left)
+		eval, constant = func(st *Runtime) reflect.Value {
+			return evalAdditive(node, left(st), right(st))
+		}, leftConst && rightConst
+	case *MultiplicativeExprNode:
+		base = &node.NodeBase
+		left, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		eval, constant = func(st *Runtime) reflect.Value {
+			return evalMultiplicative(node, left(st), right(st))
+		}, leftConst && rightConst
+	case *ComparativeExprNode:
+		base = &node.NodeBase
+		left, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		eval, constant = func(st *Runtime) reflect.Value {
+			return evalComparative(node, left(st), right(st))
+		}, leftConst && rightConst
+	case *NumericComparativeExprNode:
+		base = &node.NodeBase
+		left, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		eval, constant = func(st *Runtime) reflect.Value {
+			return evalNumericComparative(node, left(st), right(st))
+		}, leftConst && rightConst
+	case *LogicalExprNode:
+		base = &node.NodeBase
+		left, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		if node.Operator.typ == itemAnd {
+			eval = func(st *Runtime) reflect.Value {
+				return reflect.ValueOf(isTrue(left(st)) && isTrue(right(st)))
+			}
+		} else {
+			eval = func(st *Runtime) reflect.Value {
+				return reflect.ValueOf(isTrue(left(st)) || isTrue(right(st)))
+			}
+		}
+		constant = leftConst && rightConst
+	case *CoalesceExprNode:
+		base = &node.NodeBase
+		_, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		eval, constant = func(st *Runtime) reflect.Value {
+			if left, ok := st.evalIfSet(node.Left); ok {
+				return left
+			}
+			return right(st)
+		}, leftConst && rightConst
+	case *MapLiteralNode:
+		// never constant: each evaluation creates a new map, which templates can modify
+		base = &node.NodeBase
+		keys, values := compileExprs(node.Keys), compileExprs(node.Values)
+		eval = func(st *Runtime) reflect.Value {
+			m := make(map[string]interface{}, len(keys))
+			for i, key := range keys {
+				setMapLiteralEntry(node, m, key(st), values[i](st))
+			}
+			return reflect.ValueOf(m)
+		}
+	case *SliceLiteralNode:
+		base = &node.NodeBase
+		items := compileExprs(node.Items)
+		eval = func(st *Runtime) reflect.Value {
+			s := make([]interface{}, len(items))
+			for i, item := range items {
+				s[i] = valueInterface(item(st))
+			}
+			return reflect.ValueOf(s)
+		}
+	case *NotExprNode:
+		base = &node.NodeBase
+		expr, exprConst := compileExprConst(node.Expr)
+		eval, constant = func(st *Runtime) reflect.Value {
+			return reflect.ValueOf(!isTrue(expr(st)))
+		}, exprConst
+	case *TernaryExprNode:
+		base = &node.NodeBase
+		boolean, booleanConst := compileExprConst(node.Boolean)
+		left, leftConst := compileExprConst(node.Left)
+		right, rightConst := compileExprConst(node.Right)
+		if booleanConst {
+			// only one branch can ever be taken
+			if folded, ok := foldConstant(boolean); ok {
+				if isTrue(folded(nil)) {
+					eval, constant = left, leftConst
+				} else {
+					eval, constant = right, rightConst
+				}
+				break
+			}
+		}
+		eval = func(st *Runtime) reflect.Value {
+			if isTrue(boolean(st)) {
+				return left(st)
+			}
+			return right(st)
+		}
+	case *CallExprNode:
+		base = &node.NodeBase
+		compileArgs(node.CallArgs)
+		baseExpr := compileBaseExpr(node.BaseExpr)
+		eval = func(st *Runtime) reflect.Value {
+			return st.evalCallExprNode(node, baseExpr(st))
+		}
+	case *IndexExprNode:
+		base = &node.NodeBase
+		baseExpr := compileExpr(node.Base)
+		index := compileExpr(node.Index)
+		eval = func(st *Runtime) reflect.Value {
+			return st.evalIndexExpression(node, baseExpr(st), index(st))
+		}
+	case *SliceExprNode:
+		base = &node.NodeBase
+		compileExpr(node.Base)
+		if node.Index != nil {
+			compileExpr(node.Index)
+		}
+		if node.EndIndex != nil {
+			compileExpr(node.EndIndex)
+		}
+		eval = func(st *Runtime) reflect.Value {
+			return st.evalSliceExpression(node)
+		}
+	case *LambdaExprNode:
+		base = &node.NodeBase
+		compileExpr(node.Body)
+		eval = func(st *Runtime) reflect.Value {
+			return st.evalLambdaExpression(node)
+		}
+	default:
+		return interpreted(node), false
+	}
+
+	if constant {
+		if folded, ok := foldConstant(eval); ok {
+			eval = folded
+		} else {
+			// evaluating the expression fails, which has to happen when the template is executed
+			constant = false
+		}
+	}
+
+	base.eval = eval
+	return eval, constant
+}
+
+func compileExprs(nodes []Expression) []evalFunc {
+	evals := make([]evalFunc, len(nodes))
+	for i, node := range nodes {
+		evals[i] = compileExpr(node)
+	}
+	return evals
+}
+
+// compileBaseExpr compiles the base expression of a call. Only the node types handled by
+// evalBaseExpressionGroup can be called.
+func compileBaseExpr(node Expression) evalFunc {
+	switch node.Type() {
+	case NodeNil, NodeBool, NodeString, NodeIdentifier, NodeField, NodeChain, NodeNumber:
+		return compileExpr(node)
+	}
+	return func(st *Runtime) reflect.Value {
+		return st.evalBaseExpressionGroup(node)
+	}
+}
+
+func interpreted(node Expression) evalFunc {
+	return func(st *Runtime) reflect.Value {
+		return st.evalPrimaryExpressionGroup(node)
+	}
+}
+
+func constantEval(v reflect.Value) evalFunc {
+	return func(*Runtime) reflect.Value {
+		return v
+	}
+}
+
+// foldConstant evaluates a constant expression. It fails if the evaluation panics.
+func foldConstant(eval evalFunc) (folded evalFunc, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return constantEval(eval(nil)), true
+}
+
+func compileFieldNode(node *FieldNode) evalFunc {
+	accesses := make([]*fieldAccess, len(node.Ident))
+	for i, name := range node.Ident {
+		accesses[i] = &fieldAccess{name: name}
+	}
+	return func(st *Runtime) reflect.Value {
+		resolved := st.context
+		for i := 0; i < len(accesses); i++ {
+			field, err := accesses[i].resolve(resolved)
+			if err != nil {
+				node.errorf("%v", err)
+			}
+			if !field.IsValid() {
+				node.errorf("there is no field or method '%s' in %s (.%s)", node.Ident[i], getTypeString(resolved), strings.Join(node.Ident, "."))
+			}
+			resolved = field
+		}
+		return resolved
+	}
+}
+
+func compileChainNode(node *ChainNode) evalFunc {
+	baseExpr := compileExpr(node.Node)
+	accesses := make([]*fieldAccess, len(node.Field))
+	for i, name := range node.Field {
+		accesses[i] = &fieldAccess{name: name}
+	}
+	return func(st *Runtime) reflect.Value {
+		resolved := baseExpr(st)
+		for i := 0; i < len(accesses); i++ {
+			if node.optional(i) && !notNil(resolved) {
+				return reflect.Value{}
+			}
+			field, err := accesses[i].resolve(resolved)
+			if err != nil {
+				node.error(err)
+			}
+			if !field.IsValid() {
+				if resolved.Kind() == reflect.Map && (i == len(node.Field)-1 || node.optional(i+1)) {
+					return reflect.Value{}
+				}
+				node.errorf("there is no field or method '%s' in %s (%s)", node.Field[i], getTypeString(resolved), node)
+			}
+			resolved = field
+		}
+		return resolved
+	}
+}
+
+// fieldAccess resolves a field or method by name, like resolveIndex. When the name resolves to a struct field,
+// the field index is remembered together with the struct type, so the next access on a value of the same type
+// goes straight to the field.
+type fieldAccess struct {
+	name   string
+	cached atomic.Value // *cachedField
+}
+
+type cachedField struct {
+	typ   reflect.Type
+	index []int
+}
+
+func (f *fieldAccess) resolve(v reflect.Value) (reflect.Value, error) {
+	if cached, _ := f.cached.Load().(*cachedField); cached != nil {
+		if rv, isNil := indirect(v); !isNil && rv.Kind() == reflect.Struct && rv.Type() == cached.typ {
+			return indirectEface(rv.FieldByIndex(cached.index)), nil
+		}
+	}
+
+	resolved, err := resolveIndex(v, reflect.Value{}, f.name)
+	if err == nil {
+		if rv, isNil := indirect(v); !isNil && rv.Kind() == reflect.Struct {
+			typ := rv.Type()
+			// methods take precedence over fields, so only cache fields of types without a method of that name;
+			// the fields of loop aren't cached since reading loop.last looks for the next iteration
+			if _, isMethod := methodIndex(reflect.PtrTo(typ), f.name); !isMethod && typ != loopType {
+				if index, ok := fieldIndex(typ, f.name); ok {
+					f.cached.Store(&cachedField{typ: typ, index: index})
+				}
+			}
+		}
+	}
+	return resolved, err
+}
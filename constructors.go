@@ -24,6 +24,18 @@ func (t *Template) newSliceExpr(pos Pos, line int, base, index, endIndex Express
 	return &SliceExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeSliceExpr, Pos: pos, Line: line}, Index: index, Base: base, EndIndex: endIndex}
 }
 
+func (t *Template) newMapLiteral(pos Pos, line int) *MapLiteralNode {
+	return &MapLiteralNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeMapLiteral, Pos: pos, Line: line}}
+}
+
+func (t *Template) newSliceLiteral(pos Pos, line int) *SliceLiteralNode {
+	return &SliceLiteralNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeSliceLiteral, Pos: pos, Line: line}}
+}
+
+func (t *Template) newLambdaExpr(pos Pos, line int, params []*IdentifierNode, body Expression) *LambdaExprNode {
+	return &LambdaExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeLambdaExpr, Pos: pos, Line: line}, Params: params, Body: body}
+}
+
 func (t *Template) newIndexExpr(pos Pos, line int, base, index Expression) *IndexExprNode {
 	return &IndexExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeIndexExpr, Pos: pos, Line: line}, Index: index, Base: base}
 }
@@ -32,8 +44,8 @@ func (t *Template) newTernaryExpr(pos Pos, line int, boolean, left, right Expres
 	return &TernaryExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeTernaryExpr, Pos: pos, Line: line}, Boolean: boolean, Left: left, Right: right}
 }
 
-func (t *Template) newSet(pos Pos, line int, isLet, isIndexExprGetLookup bool, left, right []Expression) *SetNode {
-	return &SetNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeSet, Pos: pos, Line: line}, Let: isLet, IndexExprGetLookup: isIndexExprGetLookup, Left: left, Right: right}
+func (t *Template) newSet(pos Pos, line int, isLet, isIndexExprGetLookup, isMultiValueCall bool, left, right []Expression) *SetNode {
+	return &SetNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeSet, Pos: pos, Line: line}, Let: isLet, IndexExprGetLookup: isIndexExprGetLookup, MultiValueCall: isMultiValueCall, Left: left, Right: right}
 }
 
 func (t *Template) newCallExpr(pos Pos, line int, expr Expression) *CallExprNode {
@@ -46,8 +58,12 @@ func (t *Template) newNumericComparativeExpr(pos Pos, line int, left, right Expr
 	return &NumericComparativeExprNode{binaryExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeNumericComparativeExpr, Pos: pos, Line: line}, Operator: item, Left: left, Right: right}}
 }
 
+func (t *Template) newCoalesceExpr(pos Pos, line int, left, right Expression, item item) *CoalesceExprNode {
+	return &CoalesceExprNode{binaryExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeCoalesceExpr, Pos: pos, Line: line}, Operator: item, Left: left, Right: right}}
+}
+
 func (t *Template) newComparativeExpr(pos Pos, line int, left, right Expression, item item) *ComparativeExprNode {
-	return &ComparativeExprNode{binaryExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeComparativeExpr, Pos: pos, Line: line}, Operator: item, Left: left, Right: right}}
+	return &ComparativeExprNode{binaryExprNode: binaryExprNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeComparativeExpr, Pos: pos, Line: line}, Operator: item, Left: left, Right: right}}
 }
 
 func (t *Template) newLogicalExpr(pos Pos, line int, left, right Expression, item item) *LogicalExprNode {
@@ -119,7 +135,23 @@ func (t *Template) newIf(pos Pos, line int, set *SetNode, pipe Expression, list,
 }
 
 func (t *Template) newRange(pos Pos, line int, set *SetNode, pipe Expression, list, elseList *ListNode) *RangeNode {
-	return &RangeNode{BranchNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeRange, Pos: pos, Line: line}, Set: set, Expression: pipe, List: list, ElseList: elseList}}
+	return &RangeNode{BranchNode: BranchNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeRange, Pos: pos, Line: line}, Set: set, Expression: pipe, List: list, ElseList: elseList}}
+}
+
+func (t *Template) newWith(pos Pos, line int, set *SetNode, pipe Expression, list, elseList *ListNode) *WithNode {
+	return &WithNode{BranchNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeWith, Pos: pos, Line: line}, Set: set, Expression: pipe, List: list, ElseList: elseList}}
+}
+
+func (t *Template) newSwitch(pos Pos, line int, set *SetNode, expression Expression) *SwitchNode {
+	return &SwitchNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeSwitch, Pos: pos, Line: line}, Set: set, Expression: expression}
+}
+
+func (t *Template) newCase(pos Pos, line int, expressions []Expression) *CaseNode {
+	return &CaseNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeCase, Pos: pos, Line: line}, Expressions: expressions}
+}
+
+func (t *Template) newDefault(pos Pos, line int) *defaultNode {
+	return &defaultNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: nodeDefault, Pos: pos, Line: line}}
 }
 
 func (t *Template) newBlock(pos Pos, line int, name string, parameters *BlockParameterList, pipe Expression, listNode, contentListNode *ListNode) *BlockNode {
@@ -130,14 +162,22 @@ func (t *Template) newYield(pos Pos, line int, name string, bplist *BlockParamet
 	return &YieldNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeYield, Pos: pos, Line: line}, Name: name, Parameters: bplist, Expression: pipe, Content: content, IsContent: isContent}
 }
 
-func (t *Template) newInclude(pos Pos, line int, name, context Expression) *IncludeNode {
-	return &IncludeNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeInclude, Pos: pos, Line: line}, Name: name, Context: context}
+func (t *Template) newInclude(pos Pos, line int, name, context Expression, async bool) *IncludeNode {
+	return &IncludeNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeInclude, Pos: pos, Line: line}, Name: name, Context: context, Async: async}
 }
 
 func (t *Template) newReturn(pos Pos, line int, pipe Expression) *ReturnNode {
 	return &ReturnNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeReturn, Pos: pos, Line: line}, Value: pipe}
 }
 
+func (t *Template) newBreak(pos Pos, line int) *BreakNode {
+	return &BreakNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeBreak, Pos: pos, Line: line}}
+}
+
+func (t *Template) newContinue(pos Pos, line int) *ContinueNode {
+	return &ContinueNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeContinue, Pos: pos, Line: line}}
+}
+
 func (t *Template) newTry(pos Pos, line int, list *ListNode, catch *catchNode) *TryNode {
 	return &TryNode{NodeBase: NodeBase{TemplatePath: t.Name, NodeType: NodeTry, Pos: pos, Line: line}, List: list, Catch: catch}
 }
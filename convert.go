@@ -0,0 +1,352 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// bindableField is a struct field values can be bound to by name.
+type bindableField struct {
+	name     string
+	index    []int
+	typ      reflect.Type
+	required bool
+}
+
+// bindableFields returns the exported fields of the struct type typ, in declaration order, named by their `jet`
+// tag if present. Fields tagged with `jet:"-"` are skipped. The fields of embedded structs without a name in their
+// tag are promoted like in Go: they take the place of the embedded struct, unless a field with the same name is
+// declared at a shallower depth.
+func bindableFields(typ reflect.Type) []bindableField {
+	fields := appendBindableFields(nil, typ, nil)
+	promoted := make([]bindableField, 0, len(fields))
+	for _, field := range fields {
+		shadowed := false
+		for _, other := range fields {
+			if len(other.index) < len(field.index) && strings.EqualFold(other.name, field.name) {
+				shadowed = true
+				break
+			}
+		}
+		if !shadowed {
+			promoted = append(promoted, field)
+		}
+	}
+	return promoted
+}
+
+func appendBindableFields(fields []bindableField, typ reflect.Type, index []int) []bindableField {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, required := field.Name, false
+		tag, tagged := field.Tag.Lookup("jet")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && parts[0] == "" {
+			fields = appendBindableFields(fields, field.Type, fieldIndex)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if tagged {
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "required" {
+					required = true
+				}
+			}
+		}
+		fields = append(fields, bindableField{name: name, index: fieldIndex, typ: field.Type, required: required})
+	}
+	return fields
+}
+
+// convertValue converts v so it can be used as a value of type to, e.g. as an argument of a Go function.
+// Numbers are converted between all numeric types as long as the value fits the target type; floats converted
+// to integers are truncated like Go conversions do. Strings are parsed into numbers and bools. Numbers, bools
+// and fmt.Stringer implementations are formatted into strings. Slices, arrays and maps are converted element by
+// element. Maps with string keys are converted into structs field by field, matching keys to field names
+// ignoring case. Lambdas are converted into Go funcs.
+func convertValue(v reflect.Value, to reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		switch to.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(to), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use nil as %s", to)
+	}
+
+	if v.Type().AssignableTo(to) {
+		return v, nil
+	}
+
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return convertValue(reflect.Value{}, to)
+		}
+		return convertValue(v.Elem(), to)
+	}
+
+	kind := v.Kind()
+	switch toKind := to.Kind(); {
+	case isInt(toKind):
+		i, err := convertToInt(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		r := reflect.New(to).Elem()
+		if r.OverflowInt(i) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", i, to)
+		}
+		r.SetInt(i)
+		return r, nil
+	case isUint(toKind):
+		u, err := convertToUint(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		r := reflect.New(to).Elem()
+		if r.OverflowUint(u) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", u, to)
+		}
+		r.SetUint(u)
+		return r, nil
+	case isFloat(toKind):
+		f, err := convertToFloat(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		r := reflect.New(to).Elem()
+		if r.OverflowFloat(f) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", f, to)
+		}
+		r.SetFloat(f)
+		return r, nil
+	case toKind == reflect.String:
+		var s string
+		switch {
+		case kind == reflect.String:
+			s = v.String()
+		case v.Type().Implements(stringerType):
+			s = v.Interface().(fmt.Stringer).String()
+		case isInt(kind):
+			s = strconv.FormatInt(v.Int(), 10)
+		case isUint(kind):
+			s = strconv.FormatUint(v.Uint(), 10)
+		case isFloat(kind):
+			s = strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		case kind == reflect.Bool:
+			s = strconv.FormatBool(v.Bool())
+		case kind == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			s = string(v.Bytes())
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use %s as %s", getTypeString(v), to)
+		}
+		return reflect.ValueOf(s).Convert(to), nil
+	case toKind == reflect.Bool:
+		switch kind {
+		case reflect.Bool:
+			return v.Convert(to), nil
+		case reflect.String:
+			b, err := strconv.ParseBool(v.String())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot use %q as %s", v.String(), to)
+			}
+			return reflect.ValueOf(b).Convert(to), nil
+		}
+	case toKind == reflect.Slice:
+		if kind != reflect.Slice && kind != reflect.Array {
+			break
+		}
+		if kind == reflect.Slice && v.IsNil() {
+			return reflect.Zero(to), nil
+		}
+		r := reflect.MakeSlice(to, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := convertValue(v.Index(i), to.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %v", i, err)
+			}
+			r.Index(i).Set(elem)
+		}
+		return r, nil
+	case toKind == reflect.Map:
+		if kind != reflect.Map {
+			break
+		}
+		if v.IsNil() {
+			return reflect.Zero(to), nil
+		}
+		r := reflect.MakeMapWithSize(to, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := convertValue(iter.Key(), to.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %v", iter.Key(), err)
+			}
+			elem, err := convertValue(iter.Value(), to.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("value for key %v: %v", iter.Key(), err)
+			}
+			r.SetMapIndex(key, elem)
+		}
+		return r, nil
+	case toKind == reflect.Struct:
+		if kind != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		r := reflect.New(to).Elem()
+		for _, field := range bindableFields(to) {
+			elem := mapIndexFold(v, field.name)
+			if !elem.IsValid() {
+				if field.required {
+					return reflect.Value{}, fmt.Errorf("missing required key %q", field.name)
+				}
+				continue
+			}
+			fv, err := convertValue(elem, field.typ)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("value for key %s: %v", field.name, err)
+			}
+			r.FieldByIndex(field.index).Set(fv)
+		}
+		return r, nil
+	case toKind == reflect.Func:
+		if v.Type() == lambdaType && !v.IsNil() {
+			return v.Interface().(*Lambda).funcOf(to)
+		}
+	case toKind == reflect.Ptr:
+		if kind == reflect.Ptr {
+			break
+		}
+		elem, err := convertValue(v, to.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		r := reflect.New(to.Elem())
+		r.Elem().Set(elem)
+		return r, nil
+	}
+
+	if kind == reflect.Ptr && !v.IsNil() {
+		return convertValue(v.Elem(), to)
+	}
+
+	if v.Type().ConvertibleTo(to) {
+		return v.Convert(to), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", getTypeString(v), to)
+}
+
+// mapIndexFold returns the value of the key name in m, a map with string keys, or the value of a key equal to name
+// ignoring case, like named arguments are matched against struct fields.
+func mapIndexFold(m reflect.Value, name string) reflect.Value {
+	if elem := m.MapIndex(reflect.ValueOf(name).Convert(m.Type().Key())); elem.IsValid() {
+		return elem
+	}
+	iter := m.MapRange()
+	for iter.Next() {
+		if strings.EqualFold(iter.Key().String(), name) {
+			return iter.Value()
+		}
+	}
+	return reflect.Value{}
+}
+
+func convertToInt(v reflect.Value) (int64, error) {
+	kind := v.Kind()
+	switch {
+	case isInt(kind):
+		return v.Int(), nil
+	case isUint(kind):
+		if u := v.Uint(); u > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows int64", u)
+		}
+		return int64(v.Uint()), nil
+	case isFloat(kind):
+		f := math.Trunc(v.Float())
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows int64", f)
+		}
+		return int64(f), nil
+	case kind == reflect.String:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot use %q as an integer", v.String())
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("cannot use %s as an integer", getTypeString(v))
+}
+
+func convertToUint(v reflect.Value) (uint64, error) {
+	kind := v.Kind()
+	switch {
+	case isUint(kind):
+		return v.Uint(), nil
+	case isInt(kind):
+		if i := v.Int(); i < 0 {
+			return 0, fmt.Errorf("%v is negative", i)
+		}
+		return uint64(v.Int()), nil
+	case isFloat(kind):
+		f := math.Trunc(v.Float())
+		if f < 0 {
+			return 0, fmt.Errorf("%v is negative", f)
+		}
+		if f >= math.MaxUint64 {
+			return 0, fmt.Errorf("%v overflows uint64", f)
+		}
+		return uint64(f), nil
+	case kind == reflect.String:
+		u, err := strconv.ParseUint(v.String(), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot use %q as an unsigned integer", v.String())
+		}
+		return u, nil
+	}
+	return 0, fmt.Errorf("cannot use %s as an unsigned integer", getTypeString(v))
+}
+
+func convertToFloat(v reflect.Value) (float64, error) {
+	kind := v.Kind()
+	switch {
+	case isFloat(kind):
+		return v.Float(), nil
+	case isInt(kind):
+		return float64(v.Int()), nil
+	case isUint(kind):
+		return float64(v.Uint()), nil
+	case kind == reflect.String:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot use %q as a number", v.String())
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("cannot use %s as a number", getTypeString(v))
+}
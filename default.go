@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"reflect"
+	"sort"
 	"strings"
 	"text/template"
 )
@@ -50,6 +51,8 @@ func init() {
 		"map":       reflect.ValueOf(newMap),
 		"slice":     reflect.ValueOf(newSlice),
 		"array":     reflect.ValueOf(newSlice),
+		"filter":    reflect.ValueOf(filterCollection),
+		"sortBy":    reflect.ValueOf(sortCollection),
 		"isset": reflect.ValueOf(Func(func(a Arguments) reflect.Value {
 			a.RequireNumOfArguments("isset", 1, -1)
 			for i := 0; i < a.NumOfArguments(); i++ {
@@ -59,6 +62,13 @@ func init() {
 			}
 			return valueBoolTRUE
 		})),
+		"deepEqual": reflect.ValueOf(Func(func(a Arguments) reflect.Value {
+			a.RequireNumOfArguments("deepEqual", 2, 2)
+			if deepEquality(a.Get(0), a.Get(1)) {
+				return valueBoolTRUE
+			}
+			return valueBoolFALSE
+		})),
 		"len": reflect.ValueOf(Func(func(a Arguments) reflect.Value {
 			a.RequireNumOfArguments("len", 1, 1)
 
@@ -91,7 +101,13 @@ func init() {
 				return hiddenFalse
 			}
 
-			a.runtime.newScope()
+			if a.NumOfArguments() > 1 {
+				c := a.runtime.context
+				defer func() { a.runtime.context = c }()
+				a.runtime.context = a.Get(1)
+			}
+
+			a.runtime.newScope(nil)
 			defer a.runtime.releaseScope()
 
 			a.runtime.blocks = t.processedBlocks
@@ -100,12 +116,6 @@ func init() {
 				root = t.extends.Root
 			}
 
-			if a.NumOfArguments() > 1 {
-				c := a.runtime.context
-				defer func() { a.runtime.context = c }()
-				a.runtime.context = a.Get(1)
-			}
-
 			a.runtime.executeList(root)
 
 			return hiddenTrue
@@ -117,7 +127,13 @@ func init() {
 				panic(fmt.Errorf("exec(%s, %v): %w", a.Get(0), a.Get(1), err))
 			}
 
-			a.runtime.newScope()
+			if a.NumOfArguments() > 1 {
+				c := a.runtime.context
+				defer func() { a.runtime.context = c }()
+				a.runtime.context = a.Get(1)
+			}
+
+			a.runtime.newScope(nil)
 			defer a.runtime.releaseScope()
 
 			w := a.runtime.Writer
@@ -130,12 +146,7 @@ func init() {
 				root = t.extends.Root
 			}
 
-			if a.NumOfArguments() > 1 {
-				c := a.runtime.context
-				defer func() { a.runtime.context = c }()
-				a.runtime.context = a.Get(1)
-			}
-			result = a.runtime.executeList(root)
+			result = a.runtime.executeListFlushing(root)
 
 			return result
 		})),
@@ -217,6 +228,12 @@ var newMap = Func(func(a Arguments) reflect.Value {
 			a.Panicf("map(): key argument at position %d is not a valid value!", i)
 		}
 		if !key.Type().ConvertibleTo(stringType) {
+			// map(collection, fn) maps the items of collection using the lambda fn; collections can't be keys
+			if a.NumOfArguments() == 2 {
+				if fn := indirectInterface(a.Get(1)); fn.IsValid() && fn.Type() == lambdaType {
+					return mapCollection(a, key, fn)
+				}
+			}
 			a.Panicf("map(): can't use %+v as string key: %s is not convertible to string", key, key.Type())
 		}
 		m.SetMapIndex(a.Get(i), a.Get(i+1))
@@ -225,6 +242,148 @@ var newMap = Func(func(a Arguments) reflect.Value {
 	return m
 })
 
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// rangeCollection calls fn with the key and value of each item of collection, which can be any value a range
+// statement accepts.
+func rangeCollection(a Arguments, name string, collection reflect.Value, fn func(key, value reflect.Value)) {
+	ranger, cleanup, err := getRanger(collection)
+	if err != nil {
+		a.Panicf("%s(): %v", name, err)
+	}
+	defer cleanup()
+	for {
+		key, value, end := ranger.Range()
+		if end {
+			return
+		}
+		fn(key, value)
+	}
+}
+
+// collectionKind returns the kind of collection and the type of its items: slices, arrays, maps and channels keep
+// the type of their elements, the items of other rangers are interface{} values.
+func collectionKind(collection reflect.Value) (reflect.Kind, reflect.Type) {
+	if collection.IsValid() && collection.Type().Implements(rangerType) {
+		return reflect.Interface, interfaceType
+	}
+	v, _ := indirect(collection)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return v.Kind(), v.Type().Elem()
+	}
+	return reflect.Interface, interfaceType
+}
+
+// itemOf returns value as an item of type typ, using the zero value of typ for nil.
+func itemOf(value reflect.Value, typ reflect.Type) reflect.Value {
+	if !value.IsValid() {
+		return reflect.Zero(typ)
+	}
+	return value
+}
+
+// filterCollection returns the items of a collection for which a function returns true. Maps are filtered into
+// maps of the same type, other collections into slices.
+var filterCollection = Func(func(a Arguments) reflect.Value {
+	a.RequireNumOfArguments("filter", 2, 2)
+	collection, fn := a.Get(0), a.Get(1)
+	kind, elem := collectionKind(collection)
+	if kind == reflect.Map {
+		v, _ := indirect(collection)
+		filtered := reflect.MakeMap(v.Type())
+		rangeCollection(a, "filter", collection, func(key, value reflect.Value) {
+			if isTrue(a.Call(fn, value)) {
+				filtered.SetMapIndex(key, value)
+			}
+		})
+		return filtered
+	}
+	filtered := reflect.MakeSlice(reflect.SliceOf(elem), 0, 0)
+	rangeCollection(a, "filter", collection, func(_, value reflect.Value) {
+		if isTrue(a.Call(fn, value)) {
+			filtered = reflect.Append(filtered, itemOf(value, elem))
+		}
+	})
+	return filtered
+})
+
+// mapCollection returns the results of calling fn with each item of collection. Maps are mapped into maps with
+// the same keys, other collections into slices.
+func mapCollection(a Arguments, collection, fn reflect.Value) reflect.Value {
+	kind, _ := collectionKind(collection)
+	if kind == reflect.Map {
+		v, _ := indirect(collection)
+		mapped := reflect.MakeMap(reflect.MapOf(v.Type().Key(), interfaceType))
+		rangeCollection(a, "map", collection, func(key, value reflect.Value) {
+			mapped.SetMapIndex(key, itemOf(a.Call(fn, value), interfaceType))
+		})
+		return mapped
+	}
+	mapped := reflect.MakeSlice(reflect.SliceOf(interfaceType), 0, 0)
+	rangeCollection(a, "map", collection, func(_, value reflect.Value) {
+		mapped = reflect.Append(mapped, itemOf(a.Call(fn, value), interfaceType))
+	})
+	return mapped
+}
+
+// sortCollection returns the items of a collection in a slice, stably sorted by the keys a function returns for
+// them. Keys are compared like with the < operator: numbers by value, strings lexically, and times and values
+// with a Compare method using it.
+var sortCollection = Func(func(a Arguments) reflect.Value {
+	a.RequireNumOfArguments("sortBy", 2, 2)
+	collection, fn := a.Get(0), a.Get(1)
+	_, elem := collectionKind(collection)
+	items := reflect.MakeSlice(reflect.SliceOf(elem), 0, 0)
+	var keys []reflect.Value
+	rangeCollection(a, "sortBy", collection, func(_, value reflect.Value) {
+		items = reflect.Append(items, itemOf(value, elem))
+		keys = append(keys, indirectInterface(a.Call(fn, value)))
+	})
+
+	indexes := make([]int, len(keys))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		c, err := compareKeys(keys[indexes[i]], keys[indexes[j]])
+		if err != nil {
+			a.Panicf("sortBy(): %v", err)
+		}
+		return c < 0
+	})
+
+	sorted := reflect.MakeSlice(items.Type(), len(indexes), len(indexes))
+	for i, index := range indexes {
+		sorted.Index(i).Set(items.Index(index))
+	}
+	return sorted
+})
+
+// compareKeys returns -1, 0 or +1 depending on whether left is less than, equal to or greater than right.
+func compareKeys(left, right reflect.Value) (int, error) {
+	if classifyNumber(left) != notANumber && classifyNumber(right) != notANumber {
+		less, err := compareNumbers(itemLess, left, right)
+		if err != nil {
+			return 0, err
+		}
+		greater, err := compareNumbers(itemGreat, left, right)
+		if err != nil {
+			return 0, err
+		}
+		return order(less, greater), nil
+	}
+	if left.Kind() == reflect.String && right.Kind() == reflect.String {
+		return strings.Compare(left.String(), right.String()), nil
+	}
+	if left.IsValid() && right.IsValid() {
+		if c, ok := compareOrdered(left, right); ok {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", getTypeString(left), getTypeString(right))
+}
+
 var newSlice = Func(func(a Arguments) reflect.Value {
 	arr := make([]interface{}, a.NumOfArguments())
 	for i := 0; i < a.NumOfArguments(); i++ {
@@ -10,7 +10,7 @@ import (
 // dumpAll returns
 //  - everything in Runtime.context
 //  - everything in Runtime.variables
-//  - everything in Runtime.set.globals
+//  - everything in Runtime.globals
 //  - everything in Runtime.blocks
 func dumpAll(a Arguments, depth int) reflect.Value {
 	var b bytes.Buffer
@@ -23,7 +23,7 @@ func dumpAll(a Arguments, depth int) reflect.Value {
 	dumpScopeVars(&b, a.runtime.scope, 0)
 	dumpScopeVarsToDepth(&b, a.runtime.parent, depth)
 
-	vars = a.runtime.set.globals
+	vars = a.runtime.globals
 	for i, name := range vars.SortedKeys() {
 		if i == 0 {
 			fmt.Fprintln(&b, "Globals:")
@@ -64,7 +64,7 @@ func dumpScopeVars(w io.Writer, scope *scope, lvl int) {
 	} else {
 		fmt.Fprintf(w, "Variables in scope %d level(s) up:\n", lvl)
 	}
-	vars := scope.variables
+	vars := scope.vars()
 	for _, k := range vars.SortedKeys() {
 		fmt.Fprintf(w, "\t%s=%#v\n", k, vars[k])
 	}
@@ -19,25 +19,27 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/CloudyKit/fastprinter"
 )
 
 var (
 	funcType       = reflect.TypeOf(Func(nil))
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
 	stringerType   = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
 	rangerType     = reflect.TypeOf((*Ranger)(nil)).Elem()
 	rendererType   = reflect.TypeOf((*Renderer)(nil)).Elem()
 	safeWriterType = reflect.TypeOf(SafeWriter(nil))
 	pool_State     = sync.Pool{
 		New: func() interface{} {
-			return &Runtime{scope: &scope{}, escapeeWriter: new(escapeeWriter)}
+			st := &Runtime{escapeeWriter: new(escapeeWriter)}
+			st.scope = &st.root
+			return st
 		},
 	}
 )
@@ -74,19 +76,41 @@ func (w *escapeeWriter) Write(b []byte) (int, error) {
 type Runtime struct {
 	*escapeeWriter
 	*scope
+	root         scope // the top-most scope, holding the variables passed to Execute
 	content      func(*Runtime, Expression)
 	includeDepth int
+	globals      VarMap             // the set's global variables when the execution started
+	formatters   *formatterRegistry // the set's formatters when the execution started
+	loop         loopControl        // set by break and continue until the enclosing range handles it
+	async        *asyncOutput       // output segments, only used once an async include was executed
+	currentLoop  *Loop              // the innermost range loop tracking its iterations
+	execution    *execution         // shared with the lambdas created during the execution, set lazily
 
 	context reflect.Value
 }
 
+// loopControl tells lists to stop executing their nodes because of a break or continue action.
+type loopControl int
+
+const (
+	loopNone loopControl = iota
+	loopBreak
+	loopContinue
+)
+
 // Context returns the current context value
 func (r *Runtime) Context() reflect.Value {
 	return r.context
 }
 
-func (st *Runtime) newScope() {
-	st.scope = &scope{parent: st.scope, variables: make(VarMap), blocks: st.blocks}
+// newScope creates a scope for the variables in layout, which may be nil for scopes without variables declared
+// in the template.
+func (st *Runtime) newScope(layout *frameLayout) {
+	sc := &scope{parent: st.scope, layout: layout, blocks: st.blocks}
+	if layout != nil {
+		sc.slots = sc.allocSlots(len(layout.names))
+	}
+	st.scope = sc
 }
 
 func (st *Runtime) releaseScope() {
@@ -95,10 +119,20 @@ func (st *Runtime) releaseScope() {
 
 type scope struct {
 	parent    *scope
-	variables VarMap
+	layout    *frameLayout
+	slots     []slot  // variables declared in the template, as listed in layout
+	inline    [2]slot // backs slots for scopes with few variables
+	variables VarMap  // other variables, passed to Execute or declared using Let
 	blocks    map[string]*BlockNode
 }
 
+func (s *scope) allocSlots(n int) []slot {
+	if n <= len(s.inline) {
+		return s.inline[:n]
+	}
+	return make([]slot, n)
+}
+
 func (s scope) sortedBlocks() []string {
 	r := make([]string, 0, len(s.blocks))
 	for k := range s.blocks {
@@ -137,13 +171,10 @@ func (st *scope) getBlock(name string) (block *BlockNode, found bool) {
 
 func (state *Runtime) setValue(name string, val reflect.Value) error {
 	// try changing existing variable in current or parent scope
-	sc := state.scope
-	for sc != nil {
-		if _, ok := sc.variables[name]; ok {
-			sc.variables[name] = val
+	for sc := state.scope; sc != nil; sc = sc.parent {
+		if sc.assign(name, val) {
 			return nil
 		}
-		sc = sc.parent
 	}
 
 	return fmt.Errorf("could not assign %q = %v because variable %q is uninitialised", name, val, name)
@@ -153,12 +184,12 @@ func (state *Runtime) setValue(name string, val reflect.Value) error {
 func (state *Runtime) LetGlobal(name string, val interface{}) {
 	sc := state.scope
 
-	// walk up to top-most valid scope
-	for sc.parent != nil && sc.parent.variables != nil {
+	// walk up to top-most scope
+	for sc.parent != nil {
 		sc = sc.parent
 	}
 
-	sc.variables[name] = reflect.ValueOf(val)
+	sc.let(name, reflect.ValueOf(val))
 }
 
 // Set sets an existing variable in the template scope it lives in.
@@ -168,7 +199,7 @@ func (state *Runtime) Set(name string, val interface{}) error {
 
 // Let initialises a variable in the current template scope (possibly shadowing an existing variable of the same name in a parent scope).
 func (state *Runtime) Let(name string, val interface{}) {
-	state.scope.variables[name] = reflect.ValueOf(val)
+	state.scope.let(name, reflect.ValueOf(val))
 }
 
 // SetOrLet calls Set() (if a variable with the given name is visible from the current scope) or Let() (if there is no variable with the given name in the current or any parent scope).
@@ -188,30 +219,30 @@ func (state *Runtime) resolve(name string) (reflect.Value, error) {
 	}
 
 	// try current, then parent variable scopes
-	sc := state.scope
-	for sc != nil {
-		v, ok := sc.variables[name]
-		if ok {
+	for sc := state.scope; sc != nil; sc = sc.parent {
+		if v, ok := sc.lookup(name); ok {
 			return indirectEface(v), nil
 		}
-		sc = sc.parent
 	}
 
 	// try globals
-	state.set.gmx.RLock()
-	v, ok := state.set.globals[name]
-	state.set.gmx.RUnlock()
+	v, ok := state.globals[name]
 	if ok {
 		return indirectEface(v), nil
 	}
 
+	// try the loop variable of range bodies, unless a variable or global named loop hides it
+	if name == "loop" && state.currentLoop != nil {
+		return reflect.ValueOf(state.currentLoop), nil
+	}
+
 	// try default variables
 	v, ok = defaultVariables[name]
 	if ok {
 		return indirectEface(v), nil
 	}
 
-	return reflect.Value{}, fmt.Errorf("identifier %q not available in current (%+v) or parent scope, global, or default variables", name, state.scope.variables)
+	return reflect.Value{}, fmt.Errorf("identifier %q not available in current (%+v) or parent scope, global, or default variables", name, state.scope.vars())
 }
 
 // Resolve calls resolve() and ignores any errors, meaning it may return a zero reflect.Value.
@@ -231,8 +262,17 @@ func (state *Runtime) MustResolve(name string) reflect.Value {
 
 func (st *Runtime) recover(err *error) {
 	// reset state scope and context just to be safe (they might not be cleared properly if there was a panic while using the state)
-	st.scope = &scope{}
+	st.root = scope{}
+	st.scope = &st.root
 	st.context = reflect.Value{}
+	st.loop = loopNone
+	st.currentLoop = nil
+	st.globals = nil
+	st.formatters = nil
+	if st.execution != nil {
+		st.execution.finish()
+		st.execution = nil
+	}
 	pool_State.Put(st)
 	if recovered := recover(); recovered != nil {
 		var ok bool
@@ -249,7 +289,7 @@ func (st *Runtime) recover(err *error) {
 func (st *Runtime) executeSet(left Expression, right reflect.Value) {
 	typ := left.Type()
 	if typ == NodeIdentifier {
-		err := st.setValue(left.(*IdentifierNode).Ident, right)
+		err := st.assignVariable(left.(*IdentifierNode), right)
 		if err != nil {
 			left.error(err)
 		}
@@ -303,6 +343,13 @@ func (st *Runtime) executeSetList(set *SetNode) {
 				st.executeSet(set.Left[1], valueBoolFALSE)
 			}
 		}
+	} else if set.MultiValueCall {
+		values := st.evalMultiValueCall(set)
+		for i := 0; i < len(set.Left); i++ {
+			if set.Left[i].Type() != NodeUnderscore {
+				st.executeSet(set.Left[i], values[i])
+			}
+		}
 	} else {
 		for i := 0; i < len(set.Left); i++ {
 			value := st.evalPrimaryExpressionGroup(set.Right[i])
@@ -317,30 +364,74 @@ func (st *Runtime) executeLetList(set *SetNode) {
 	if set.IndexExprGetLookup {
 		value := st.evalPrimaryExpressionGroup(set.Right[0])
 		if set.Left[0].Type() != NodeUnderscore {
-			st.variables[set.Left[0].(*IdentifierNode).Ident] = value
+			st.declareVariable(set.Left[0].(*IdentifierNode), value)
 		}
 		if set.Left[1].Type() != NodeUnderscore {
 			if value.IsValid() {
-				st.variables[set.Left[1].(*IdentifierNode).Ident] = valueBoolTRUE
+				st.declareVariable(set.Left[1].(*IdentifierNode), valueBoolTRUE)
 			} else {
-				st.variables[set.Left[1].(*IdentifierNode).Ident] = valueBoolFALSE
+				st.declareVariable(set.Left[1].(*IdentifierNode), valueBoolFALSE)
+			}
+		}
+	} else if set.MultiValueCall {
+		values := st.evalMultiValueCall(set)
+		for i := 0; i < len(set.Left); i++ {
+			if set.Left[i].Type() != NodeUnderscore {
+				st.declareVariable(set.Left[i].(*IdentifierNode), values[i])
 			}
 		}
 	} else {
 		for i := 0; i < len(set.Left); i++ {
 			value := st.evalPrimaryExpressionGroup(set.Right[i])
 			if set.Left[i].Type() != NodeUnderscore {
-				st.variables[set.Left[i].(*IdentifierNode).Ident] = value
+				st.declareVariable(set.Left[i].(*IdentifierNode), value)
 			}
 		}
 	}
 }
 
+// evalMultiValueCall evaluates the call expression on the right side of set and returns all its
+// results, which must match the number of variables on the left side. Unlike in other calls, a
+// trailing error result is returned as a value instead of failing the call.
+func (st *Runtime) evalMultiValueCall(set *SetNode) []reflect.Value {
+	node := set.Right[0].(*CallExprNode)
+	baseExpr := st.evalBaseExpressionGroup(node.BaseExpr)
+	if !isCallable(baseExpr) {
+		node.errorf("node %q is not func kind %q", node.BaseExpr, baseExpr.Type())
+	}
+
+	var returns []reflect.Value
+	if baseExpr.Type() == lambdaType {
+		ret, err := st.callLambda(baseExpr.Interface().(*Lambda), node.CallArgs, nil)
+		if err != nil {
+			node.error(err)
+		}
+		returns = []reflect.Value{ret}
+	} else if funcType.AssignableTo(baseExpr.Type()) {
+		ret, err := baseExpr.Interface().(Func).call(Arguments{runtime: st, args: node.CallArgs})
+		if err != nil {
+			node.error(err)
+		}
+		returns = []reflect.Value{ret}
+	} else {
+		argValues, err := st.evaluateArgs(baseExpr.Type(), node.CallArgs, nil)
+		if err != nil {
+			node.errorf("call expression: %v", err)
+		}
+		returns = baseExpr.Call(argValues)
+	}
+
+	if len(returns) != len(set.Left) {
+		set.errorf("assignment mismatch: %d variables but %s returns %d values", len(set.Left), node.BaseExpr, len(returns))
+	}
+	return returns
+}
+
 func (st *Runtime) executeYieldBlock(block *BlockNode, blockParam, yieldParam *BlockParameterList, expression Expression, content *ListNode) {
 
 	needNewScope := len(blockParam.List) > 0 || len(yieldParam.List) > 0
 	if needNewScope {
-		st.newScope()
+		st.newScope(block.frame)
 		for i := 0; i < len(yieldParam.List); i++ {
 			p := &yieldParam.List[i]
 
@@ -348,15 +439,15 @@ func (st *Runtime) executeYieldBlock(block *BlockNode, blockParam, yieldParam *B
 				block.errorf("missing name for block parameter '%s'", blockParam.List[i].Identifier)
 			}
 
-			st.variables[p.Identifier] = st.evalPrimaryExpressionGroup(p.Expression)
+			st.scope.let(p.Identifier, st.evalPrimaryExpressionGroup(p.Expression))
 		}
 		for i := 0; i < len(blockParam.List); i++ {
 			p := &blockParam.List[i]
-			if _, found := st.variables[p.Identifier]; !found {
+			if _, found := st.scope.lookup(p.Identifier); !found {
 				if p.Expression == nil {
-					st.variables[p.Identifier] = valueBoolFALSE
+					st.scope.let(p.Identifier, valueBoolFALSE)
 				} else {
-					st.variables[p.Identifier] = st.evalPrimaryExpressionGroup(p.Expression)
+					st.scope.let(p.Identifier, st.evalPrimaryExpressionGroup(p.Expression))
 				}
 			}
 		}
@@ -401,175 +492,432 @@ func (st *Runtime) executeYieldBlock(block *BlockNode, blockParam, yieldParam *B
 	}
 }
 
+// listState holds the state shared by the nodes of a single executeList invocation.
+type listState struct {
+	inNewScope bool // the list created a scope for the variables it declares
+}
+
+// release restores the runtime after executing a list, even when a node panicked.
+func (ls *listState) release(st *Runtime) {
+	if ls.inNewScope {
+		st.releaseScope()
+	}
+}
+
 func (st *Runtime) executeList(list *ListNode) (returnValue reflect.Value) {
-	inNewScope := false // to use just one scope for multiple actions with variable declarations
+	if list.exec != nil {
+		return list.exec(st)
+	}
 
-	for i := 0; i < len(list.Nodes); i++ {
-		node := list.Nodes[i]
-		switch node.Type() {
+	ls := &listState{}
+	defer ls.release(st)
+	if list.frame != nil {
+		st.newScope(list.frame)
+		ls.inNewScope = true
+	}
 
-		case NodeText:
-			node := node.(*TextNode)
-			_, err := st.Writer.Write(node.Text)
-			if err != nil {
-				node.error(err)
-			}
-		case NodeAction:
-			node := node.(*ActionNode)
-			if node.Set != nil {
-				if node.Set.Let {
-					if !inNewScope {
-						st.newScope()
-						inNewScope = true
-						defer st.releaseScope()
-					}
-					st.executeLetList(node.Set)
-				} else {
-					st.executeSetList(node.Set)
-				}
-			}
-			if node.Pipe != nil {
-				v, safeWriter := st.evalPipelineExpression(node.Pipe)
-				if !safeWriter && v.IsValid() {
-					if v.Type().Implements(rendererType) {
-						v.Interface().(Renderer).Render(st)
-					} else {
-						_, err := fastprinter.PrintValue(st.escapeeWriter, v)
-						if err != nil {
-							node.error(err)
-						}
-					}
-				}
-			}
-		case NodeIf:
-			node := node.(*IfNode)
-			var isLet bool
-			if node.Set != nil {
-				if node.Set.Let {
-					isLet = true
-					st.newScope()
-					st.executeLetList(node.Set)
-				} else {
-					st.executeSetList(node.Set)
-				}
-			}
+	for i := 0; i < len(list.Nodes) && st.loop == loopNone; i++ {
+		if value, ok := st.executeNode(list.Nodes[i], ls); ok {
+			returnValue = value
+		}
+	}
 
-			if isTrue(st.evalPrimaryExpressionGroup(node.Expression)) {
-				returnValue = st.executeList(node.List)
-			} else if node.ElseList != nil {
-				returnValue = st.executeList(node.ElseList)
-			}
-			if isLet {
-				st.releaseScope()
-			}
-		case NodeRange:
-			node := node.(*RangeNode)
-			var expression reflect.Value
+	return returnValue
+}
 
-			isSet := node.Set != nil
-			isLet := false
-			keyVarSlot := 0
-			valVarSlot := -1
+// executeNode executes a single node of a list. ok reports whether the node produced a return value,
+// which replaces the return value of the list.
+func (st *Runtime) executeNode(node Node, ls *listState) (returnValue reflect.Value, ok bool) {
+	switch node.Type() {
+	case NodeText:
+		node := node.(*TextNode)
+		_, err := st.Writer.Write(node.Text)
+		if err != nil {
+			node.error(err)
+		}
+	case NodeAction:
+		st.executeAction(node.(*ActionNode))
+	case NodeIf:
+		return st.executeIf(node.(*IfNode)), true
+	case NodeRange:
+		return st.executeRange(node.(*RangeNode)), true
+	case NodeTry:
+		return st.executeTry(node.(*TryNode)), true
+	case NodeSwitch:
+		return st.executeSwitch(node.(*SwitchNode)), true
+	case NodeWith:
+		return st.executeWith(node.(*WithNode)), true
+	case NodeYield:
+		st.executeYield(node.(*YieldNode))
+	case NodeBlock:
+		st.executeBlock(node.(*BlockNode))
+	case NodeInclude:
+		node := node.(*IncludeNode)
+		if node.Async {
+			st.executeAsyncInclude(node)
+		} else {
+			return st.executeInclude(node), true
+		}
+	case NodeReturn:
+		node := node.(*ReturnNode)
+		return st.evalPrimaryExpressionGroup(node.Value), true
+	case NodeBreak:
+		st.loop = loopBreak
+	case NodeContinue:
+		st.loop = loopContinue
+	}
+	return reflect.Value{}, false
+}
 
-			context := st.context
+// executeAction executes an action node; the scope for variables declared by a let statement is created by the list.
+func (st *Runtime) executeAction(node *ActionNode) {
+	if node.Set != nil {
+		if node.Set.Let {
+			st.executeLetList(node.Set)
+		} else {
+			st.executeSetList(node.Set)
+		}
+	}
+	if node.Pipe != nil {
+		v, safeWriter := st.evalPipelineExpression(node.Pipe)
+		if !safeWriter {
+			st.printValue(node, v)
+		}
+	}
+}
 
-			if isSet {
-				if len(node.Set.Left) > 1 {
-					valVarSlot = 1
-				}
-				expression = st.evalPrimaryExpressionGroup(node.Set.Right[0])
-				if node.Set.Let {
-					isLet = true
-					st.newScope()
-				}
-			} else {
-				expression = st.evalPrimaryExpressionGroup(node.Expression)
+// printValue writes the result of an action to the output.
+func (st *Runtime) printValue(node Node, v reflect.Value) {
+	if !v.IsValid() {
+		return
+	}
+	if v.Type().Implements(rendererType) {
+		v.Interface().(Renderer).Render(st)
+	} else {
+		err := st.formatValue(st.escapeeWriter, v)
+		if err != nil {
+			node.error(err)
+		}
+	}
+}
+
+func (st *Runtime) executeIf(node *IfNode) (returnValue reflect.Value) {
+	var isLet bool
+	if node.Set != nil {
+		if node.Set.Let {
+			isLet = true
+			st.newScope(node.frame)
+			st.executeLetList(node.Set)
+		} else {
+			st.executeSetList(node.Set)
+		}
+	}
+
+	if isTrue(st.evalPrimaryExpressionGroup(node.Expression)) {
+		returnValue = st.executeList(node.List)
+	} else if node.ElseList != nil {
+		returnValue = st.executeList(node.ElseList)
+	}
+	if isLet {
+		st.releaseScope()
+	}
+	return returnValue
+}
+
+// executeWith executes the list with the value of the expression as context if it's true, or else the else list.
+// When the value is assigned to a variable, the variable is available in both lists.
+func (st *Runtime) executeWith(node *WithNode) (returnValue reflect.Value) {
+	var value reflect.Value
+	isLet := false
+	if node.Set != nil {
+		value = st.evalPrimaryExpressionGroup(node.Set.Right[0])
+		if node.Set.Let {
+			isLet = true
+			st.newScope(node.frame)
+			if node.Set.Left[0].Type() != NodeUnderscore {
+				st.declareVariable(node.Set.Left[0].(*IdentifierNode), value)
 			}
+		} else {
+			st.executeSet(node.Set.Left[0], value)
+		}
+	} else {
+		value = st.evalPrimaryExpressionGroup(node.Expression)
+	}
 
-			ranger, cleanup, err := getRanger(expression)
-			if err != nil {
-				node.error(err)
+	if isTrue(value) {
+		context := st.context
+		st.context = value
+		returnValue = st.executeList(node.List)
+		st.context = context
+	} else if node.ElseList != nil {
+		returnValue = st.executeList(node.ElseList)
+	}
+	if isLet {
+		st.releaseScope()
+	}
+	return returnValue
+}
+
+// executeSwitch executes the list of the first case with an expression equal to the switch expression, or the
+// default list if there is none. Case expressions are evaluated in order, until one matches.
+func (st *Runtime) executeSwitch(node *SwitchNode) (returnValue reflect.Value) {
+	var isLet bool
+	if node.Set != nil {
+		if node.Set.Let {
+			isLet = true
+			st.newScope(node.frame)
+			st.executeLetList(node.Set)
+		} else {
+			st.executeSetList(node.Set)
+		}
+	}
+
+	value := st.evalPrimaryExpressionGroup(node.Expression)
+	list := node.Default
+cases:
+	for _, c := range node.Cases {
+		for _, expression := range c.Expressions {
+			if checkEquality(value, st.evalPrimaryExpressionGroup(expression)) {
+				list = c.List
+				break cases
 			}
-			if !ranger.ProvidesIndex() {
-				if isSet && len(node.Set.Left) > 1 {
-					// two-vars assignment with ranger that doesn't provide an index
-					node.error(errors.New("two-var range over ranger that does not provide an index"))
-				} else if isSet {
-					keyVarSlot, valVarSlot = -1, 0
-				}
+		}
+	}
+	if list != nil {
+		returnValue = st.executeList(list)
+	}
+	if isLet {
+		st.releaseScope()
+	}
+	return returnValue
+}
+
+func (st *Runtime) executeRange(node *RangeNode) (returnValue reflect.Value) {
+	var expression reflect.Value
+
+	isLet := node.Set != nil && node.Set.Let
+
+	context := st.context
+
+	if node.Set != nil {
+		expression = st.evalPrimaryExpressionGroup(node.Set.Right[0])
+	} else {
+		expression = st.evalPrimaryExpressionGroup(node.Expression)
+	}
+
+	var ranger Ranger
+	var cleanup func()
+	var numbers *numberRanger
+	if node.To != nil {
+		numbers = st.numberRanger(node, expression)
+		ranger = numbers
+	} else {
+		var err error
+		ranger, cleanup, err = getRanger(expression)
+		if err != nil {
+			node.error(err)
+		}
+	}
+	offset, limit, batch := st.rangeWindow(node)
+	loop := newLoop(expression, ranger, st.currentLoop)
+	loop.Length = node.length(loop.Length, offset, limit, batch)
+
+	scope := st.scope
+	if isLet {
+		st.newScope(node.frame)
+	}
+
+	if node.Reverse {
+		ranger = reverseOf(ranger)
+	}
+	keyVarSlot, valVarSlot := rangeSlots(node, ranger)
+	if node.Where != nil {
+		ranger = &whereRanger{Ranger: ranger, st: st, node: node, keyVarSlot: keyVarSlot, valVarSlot: valVarSlot, scope: scope, context: context}
+	}
+	if offset > 0 || limit >= 0 {
+		ranger = &windowRanger{Ranger: ranger, offset: offset, limit: limit}
+	}
+	if batch > 0 {
+		ranger = &batchRanger{ranger: ranger, size: batch}
+		keyVarSlot, valVarSlot = rangeSlots(node, ranger)
+	}
+
+	loop.ranger = ranger
+	indexValue, rangeValue, end := ranger.Range()
+	if !end {
+		for !end && !returnValue.IsValid() {
+			loop.next()
+			st.currentLoop = loop
+			st.bindRangeVariables(node, keyVarSlot, valVarSlot, indexValue, rangeValue)
+			returnValue = st.executeList(node.List)
+			if st.loop == loopBreak {
+				st.loop = loopNone
+				break
 			}
+			st.loop = loopNone
+			indexValue, rangeValue, end = loop.advance()
+		}
+	} else if node.ElseList != nil {
+		returnValue = st.executeList(node.ElseList)
+	}
+	// the loop might outlive the range in a lambda, but must not use the ranger anymore
+	loop.ranger = nil
+	if numbers != nil {
+		poolNumberRanger.Put(numbers)
+	} else {
+		cleanup()
+	}
+	st.context = context
+	st.currentLoop = loop.Parent
+	if isLet {
+		st.releaseScope()
+	}
+	return returnValue
+}
 
-			indexValue, rangeValue, end := ranger.Range()
-			if !end {
-				for !end && !returnValue.IsValid() {
-					if isSet {
-						if isLet {
-							if keyVarSlot >= 0 {
-								st.variables[node.Set.Left[keyVarSlot].String()] = indexValue
-							}
-							if valVarSlot >= 0 {
-								st.variables[node.Set.Left[valVarSlot].String()] = rangeValue
-							}
-						} else {
-							if keyVarSlot >= 0 {
-								st.executeSet(node.Set.Left[keyVarSlot], indexValue)
-							}
-							if valVarSlot >= 0 {
-								st.executeSet(node.Set.Left[valVarSlot], rangeValue)
-							}
-						}
-					}
-					if valVarSlot < 0 {
-						st.context = rangeValue
-					}
-					returnValue = st.executeList(node.List)
-					indexValue, rangeValue, end = ranger.Range()
-				}
-			} else if node.ElseList != nil {
-				returnValue = st.executeList(node.ElseList)
+// rangeSlots returns the positions in the range clause of node of the variables set to the index and to the value
+// produced by ranger, or -1 when there's no such variable. The context is set to the value when valVarSlot is -1.
+func rangeSlots(node *RangeNode, ranger Ranger) (keyVarSlot, valVarSlot int) {
+	keyVarSlot, valVarSlot = 0, -1
+	if node.Set != nil && len(node.Set.Left) > 1 {
+		valVarSlot = 1
+	}
+	if !ranger.ProvidesIndex() {
+		if node.Set != nil && len(node.Set.Left) > 1 {
+			// two-vars assignment with ranger that doesn't provide an index
+			node.error(errors.New("two-var range over ranger that does not provide an index"))
+		} else if node.Set != nil {
+			keyVarSlot, valVarSlot = -1, 0
+		}
+	}
+	return keyVarSlot, valVarSlot
+}
+
+// bindRangeVariables sets the variables of the range clause of node to the index and the value of an iteration.
+func (st *Runtime) bindRangeVariables(node *RangeNode, keyVarSlot, valVarSlot int, indexValue, rangeValue reflect.Value) {
+	if set := node.Set; set != nil {
+		if set.Let {
+			if keyVarSlot >= 0 && set.Left[keyVarSlot].Type() != NodeUnderscore {
+				st.declareVariable(set.Left[keyVarSlot].(*IdentifierNode), indexValue)
 			}
-			cleanup()
-			st.context = context
-			if isLet {
-				st.releaseScope()
+			if valVarSlot >= 0 && set.Left[valVarSlot].Type() != NodeUnderscore {
+				st.declareVariable(set.Left[valVarSlot].(*IdentifierNode), rangeValue)
 			}
-		case NodeTry:
-			node := node.(*TryNode)
-			returnValue = st.executeTry(node)
-		case NodeYield:
-			node := node.(*YieldNode)
-			if node.IsContent {
-				if st.content != nil {
-					st.content(st, node.Expression)
-				}
-			} else {
-				block, found := st.getBlock(node.Name)
-				if !found || block == nil {
-					node.errorf("unresolved block %q!", node.Name)
-				}
-				st.executeYieldBlock(block, block.Parameters, node.Parameters, node.Expression, node.Content)
+		} else {
+			if keyVarSlot >= 0 {
+				st.executeSet(set.Left[keyVarSlot], indexValue)
 			}
-		case NodeBlock:
-			node := node.(*BlockNode)
-			block, found := st.getBlock(node.Name)
-			if !found {
-				block = node
+			if valVarSlot >= 0 {
+				st.executeSet(set.Left[valVarSlot], rangeValue)
 			}
-			st.executeYieldBlock(block, block.Parameters, block.Parameters, block.Expression, block.Content)
-		case NodeInclude:
-			node := node.(*IncludeNode)
-			returnValue = st.executeInclude(node)
-		case NodeReturn:
-			node := node.(*ReturnNode)
-			returnValue = st.evalPrimaryExpressionGroup(node.Value)
 		}
 	}
+	if valVarSlot < 0 {
+		st.context = rangeValue
+	}
+}
 
-	return returnValue
+// numberRanger returns a pooled ranger producing the integers of the numeric range of node, starting at from.
+func (st *Runtime) numberRanger(node *RangeNode, from reflect.Value) *numberRanger {
+	start := rangeInt(node, from, "start")
+	end := rangeInt(node, st.evalPrimaryExpressionGroup(node.To), "end")
+	step := int64(1)
+	if node.Step != nil {
+		step = rangeInt(node, st.evalPrimaryExpressionGroup(node.Step), "step")
+		if step <= 0 {
+			node.errorf("step of numeric range must be positive, but is %d", step)
+		}
+	}
+	r := poolNumberRanger.Get().(*numberRanger)
+	if !r.setup(start, end, step) {
+		poolNumberRanger.Put(r)
+		node.errorf("numeric range %d..%d step %d produces too many integers", start, end, step)
+	}
+	return r
+}
+
+// rangeWindow evaluates the arguments of the offset, limit and batch modifiers of node; limit is -1 and batch is 0
+// when the modifier isn't set.
+func (st *Runtime) rangeWindow(node *RangeNode) (offset, limit, batch int64) {
+	limit = -1
+	if node.Offset != nil {
+		if offset = rangeInt(node, st.evalPrimaryExpressionGroup(node.Offset), "offset"); offset < 0 {
+			node.errorf("offset of range can't be negative, but is %d", offset)
+		}
+	}
+	if node.Limit != nil {
+		if limit = rangeInt(node, st.evalPrimaryExpressionGroup(node.Limit), "limit"); limit < 0 {
+			node.errorf("limit of range can't be negative, but is %d", limit)
+		}
+	}
+	if node.Batch != nil {
+		if batch = rangeInt(node, st.evalPrimaryExpressionGroup(node.Batch), "batch size"); batch <= 0 {
+			node.errorf("batch size of range must be positive, but is %d", batch)
+		}
+	}
+	return offset, limit, batch
+}
+
+// rangeInt converts v, the value of the part of the range clause of node described by what, to an integer.
+func rangeInt(node *RangeNode, v reflect.Value, what string) int64 {
+	v = indirectInterface(v)
+	if !v.IsValid() || !canNumber(v.Kind()) {
+		node.errorf("%s of range is %s, not a number", what, getTypeString(v))
+	}
+	if isFloat(v.Kind()) && v.Float() != math.Trunc(v.Float()) {
+		node.errorf("%s of range must be an integer, but is %v", what, v.Float())
+	}
+	i, err := convertToInt(v)
+	if err != nil {
+		node.errorf("%s of range: %v", what, err)
+	}
+	return i
+}
+
+// length returns the number of iterations of node over a ranger producing n values with the given modifiers, or -1
+// if it can't be known before ranging.
+func (node *RangeNode) length(n int, offset, limit, batch int64) int {
+	if n < 0 || node.Where != nil {
+		return -1
+	}
+	if n -= int(offset); n < 0 {
+		n = 0
+	}
+	if limit >= 0 && int64(n) > limit {
+		n = int(limit)
+	}
+	if batch > 0 {
+		n = (n + int(batch) - 1) / int(batch)
+	}
+	return n
+}
+
+func (st *Runtime) executeYield(node *YieldNode) {
+	if node.IsContent {
+		if st.content != nil {
+			st.content(st, node.Expression)
+		}
+	} else {
+		block, found := st.getBlock(node.Name)
+		if !found || block == nil {
+			node.errorf("unresolved block %q!", node.Name)
+		}
+		st.executeYieldBlock(block, block.Parameters, node.Parameters, node.Expression, node.Content)
+	}
+}
+
+func (st *Runtime) executeBlock(node *BlockNode) {
+	block, found := st.getBlock(node.Name)
+	if !found {
+		block = node
+	}
+	st.executeYieldBlock(block, block.Parameters, block.Parameters, block.Expression, block.Content)
 }
 
 func (st *Runtime) executeTry(try *TryNode) (returnValue reflect.Value) {
 	writer := st.Writer
+	scope := st.scope
+	currentLoop := st.currentLoop
 	buf := new(bytes.Buffer)
 
 	defer func() {
@@ -579,11 +927,14 @@ func (st *Runtime) executeTry(try *TryNode) (returnValue reflect.Value) {
 		if r == nil {
 			io.Copy(writer, buf)
 		} else {
-			// st.Writer is already set to its original value since the later defer ran first
+			// st.Writer is already set to its original value since the later defer ran first,
+			// but scopes and loops created by the statements which panicked might not have been released
+			st.scope = scope
+			st.currentLoop = currentLoop
 			if try.Catch != nil {
 				if try.Catch.Err != nil {
-					st.newScope()
-					st.scope.variables[try.Catch.Err.Ident] = reflect.ValueOf(r)
+					st.newScope(try.Catch.frame)
+					st.declareVariable(try.Catch.Err, reflect.ValueOf(r))
 				}
 				if try.Catch.List != nil {
 					returnValue = st.executeList(try.Catch.List)
@@ -598,7 +949,7 @@ func (st *Runtime) executeTry(try *TryNode) (returnValue reflect.Value) {
 	st.Writer = buf
 	defer func() { st.Writer = writer }()
 
-	return st.executeList(try.List)
+	return st.executeListFlushing(try.List)
 }
 
 func (st *Runtime) executeInclude(node *IncludeNode) (returnValue reflect.Value) {
@@ -608,6 +959,31 @@ func (st *Runtime) executeInclude(node *IncludeNode) (returnValue reflect.Value)
 	st.includeDepth++
 	defer func() { st.includeDepth-- }()
 
+	t := st.resolveIncludeTemplate(node)
+
+	var context reflect.Value
+	if node.Context != nil {
+		context = st.context
+		defer func() { st.context = context }()
+		st.context = st.evalPrimaryExpressionGroup(node.Context)
+	}
+
+	st.newScope(nil)
+	defer st.releaseScope()
+
+	st.blocks = t.processedBlocks
+
+	Root := t.Root
+	for t.extends != nil {
+		t = t.extends
+		Root = t.Root
+	}
+
+	return st.executeList(Root)
+}
+
+// resolveIncludeTemplate evaluates the name of the included template and returns the template.
+func (st *Runtime) resolveIncludeTemplate(node *IncludeNode) *Template {
 	var templatePath string
 	name := st.evalPrimaryExpressionGroup(node.Name)
 	if !name.IsValid() {
@@ -624,28 +1000,8 @@ func (st *Runtime) executeInclude(node *IncludeNode) (returnValue reflect.Value)
 	t, err := st.set.getSiblingTemplate(templatePath, node.TemplatePath, true)
 	if err != nil {
 		node.error(err)
-		return reflect.Value{}
 	}
-
-	st.newScope()
-	defer st.releaseScope()
-
-	st.blocks = t.processedBlocks
-
-	var context reflect.Value
-	if node.Context != nil {
-		context = st.context
-		defer func() { st.context = context }()
-		st.context = st.evalPrimaryExpressionGroup(node.Context)
-	}
-
-	Root := t.Root
-	for t.extends != nil {
-		t = t.extends
-		Root = t.Root
-	}
-
-	return st.executeList(Root)
+	return t
 }
 
 var (
@@ -654,6 +1010,10 @@ var (
 )
 
 func (st *Runtime) evalPrimaryExpressionGroup(node Expression) reflect.Value {
+	if eval := node.compiled(); eval != nil {
+		return eval(st)
+	}
+
 	switch node.Type() {
 	case NodeAdditiveExpr:
 		return st.evalAdditiveExpression(node.(*AdditiveExprNode))
@@ -675,58 +1035,104 @@ func (st *Runtime) evalPrimaryExpressionGroup(node Expression) reflect.Value {
 		return st.evalPrimaryExpressionGroup(node.Right)
 	case NodeCallExpr:
 		node := node.(*CallExprNode)
-		baseExpr := st.evalBaseExpressionGroup(node.BaseExpr)
-		if baseExpr.Kind() != reflect.Func {
-			node.errorf("node %q is not func kind %q", node.BaseExpr, baseExpr.Type())
-		}
-		ret, err := st.evalCallExpression(baseExpr, node.CallArgs)
-		if err != nil {
-			node.error(err)
-		}
-		return ret
+		return st.evalCallExprNode(node, st.evalBaseExpressionGroup(node.BaseExpr))
 	case NodeIndexExpr:
 		node := node.(*IndexExprNode)
-		base := st.evalPrimaryExpressionGroup(node.Base)
-		index := st.evalPrimaryExpressionGroup(node.Index)
-
-		resolved, err := resolveIndex(base, index, "")
-		if err != nil {
-			node.error(err)
-		}
-		return resolved
+		return st.evalIndexExpression(node, st.evalPrimaryExpressionGroup(node.Base), st.evalPrimaryExpressionGroup(node.Index))
 	case NodeSliceExpr:
-		node := node.(*SliceExprNode)
-		baseExpression := st.evalPrimaryExpressionGroup(node.Base)
-
-		var index, length int
-		if node.Index != nil {
-			indexExpression := st.evalPrimaryExpressionGroup(node.Index)
-			if canNumber(indexExpression.Kind()) {
-				index = int(castInt64(indexExpression))
-			} else {
-				node.Index.errorf("non numeric value in index expression kind %s", indexExpression.Kind().String())
-			}
+		return st.evalSliceExpression(node.(*SliceExprNode))
+	case NodeLambdaExpr:
+		return st.evalLambdaExpression(node.(*LambdaExprNode))
+	case NodeCoalesceExpr:
+		node := node.(*CoalesceExprNode)
+		if left, ok := st.evalIfSet(node.Left); ok {
+			return left
 		}
+		return st.evalPrimaryExpressionGroup(node.Right)
+	case NodeMapLiteral:
+		node := node.(*MapLiteralNode)
+		m := make(map[string]interface{}, len(node.Keys))
+		for i, key := range node.Keys {
+			setMapLiteralEntry(node, m, st.evalPrimaryExpressionGroup(key), st.evalPrimaryExpressionGroup(node.Values[i]))
+		}
+		return reflect.ValueOf(m)
+	case NodeSliceLiteral:
+		node := node.(*SliceLiteralNode)
+		s := make([]interface{}, len(node.Items))
+		for i, item := range node.Items {
+			s[i] = valueInterface(st.evalPrimaryExpressionGroup(item))
+		}
+		return reflect.ValueOf(s)
+	}
+	return st.evalBaseExpressionGroup(node)
+}
 
-		if node.EndIndex != nil {
-			indexExpression := st.evalPrimaryExpressionGroup(node.EndIndex)
-			if canNumber(indexExpression.Kind()) {
-				length = int(castInt64(indexExpression))
-			} else {
-				node.EndIndex.errorf("non numeric value in index expression kind %s", indexExpression.Kind().String())
-			}
+// setMapLiteralEntry sets the entry key of the map m created for node to value.
+func setMapLiteralEntry(node *MapLiteralNode, m map[string]interface{}, key, value reflect.Value) {
+	key = indirectInterface(key)
+	if key.Kind() != reflect.String {
+		node.errorf("map literal key %v is %s, not a string", key, getTypeString(key))
+	}
+	m[key.String()] = valueInterface(value)
+}
+
+// valueInterface returns the value held by v, or nil if v is invalid.
+func valueInterface(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func (st *Runtime) evalCallExprNode(node *CallExprNode, baseExpr reflect.Value) reflect.Value {
+	if !isCallable(baseExpr) {
+		node.errorf("node %q is not func kind %q", node.BaseExpr, baseExpr.Type())
+	}
+	ret, err := st.evalCallExpression(baseExpr, node.CallArgs)
+	if err != nil {
+		node.error(err)
+	}
+	return ret
+}
+
+func (st *Runtime) evalIndexExpression(node *IndexExprNode, base, index reflect.Value) reflect.Value {
+	resolved, err := resolveIndex(base, index, "")
+	if err != nil {
+		node.error(err)
+	}
+	return resolved
+}
+
+func (st *Runtime) evalSliceExpression(node *SliceExprNode) reflect.Value {
+	baseExpression := st.evalPrimaryExpressionGroup(node.Base)
+
+	var index, length int
+	if node.Index != nil {
+		indexExpression := st.evalPrimaryExpressionGroup(node.Index)
+		if canNumber(indexExpression.Kind()) {
+			index = int(castInt64(indexExpression))
 		} else {
-			length = baseExpression.Len()
+			node.Index.errorf("non numeric value in index expression kind %s", indexExpression.Kind().String())
 		}
+	}
 
-		// validate bounds so an out-of-range slice yields a template error instead of a runtime panic
-		if index < 0 || length < index || length > baseExpression.Len() {
-			node.errorf("slice bounds out of range [%d:%d] with length %d", index, length, baseExpression.Len())
+	if node.EndIndex != nil {
+		indexExpression := st.evalPrimaryExpressionGroup(node.EndIndex)
+		if canNumber(indexExpression.Kind()) {
+			length = int(castInt64(indexExpression))
+		} else {
+			node.EndIndex.errorf("non numeric value in index expression kind %s", indexExpression.Kind().String())
 		}
+	} else {
+		length = baseExpression.Len()
+	}
 
-		return baseExpression.Slice(index, length)
+	// validate bounds so an out-of-range slice yields a template error instead of a runtime panic
+	if index < 0 || length < index || length > baseExpression.Len() {
+		node.errorf("slice bounds out of range [%d:%d] with length %d", index, length, baseExpression.Len())
 	}
-	return st.evalBaseExpressionGroup(node)
+
+	return baseExpression.Slice(index, length)
 }
 
 // notNil returns false when v.IsValid() == false
@@ -782,6 +1188,8 @@ func (st *Runtime) isSet(node Node) (ok bool) {
 		node := node.(*ChainNode)
 		resolved, err := st.evalChainNodeExpression(node)
 		return err == nil && notNil(resolved)
+	case NodeCoalesceExpr:
+		return notNil(st.evalPrimaryExpressionGroup(node.(*CoalesceExprNode)))
 	default:
 		//todo: maybe work some edge cases
 		if !(nodeType > beginExpressions && nodeType < endExpressions) {
@@ -791,89 +1199,41 @@ func (st *Runtime) isSet(node Node) (ok bool) {
 	return true
 }
 
-func (st *Runtime) evalNumericComparativeExpression(node *NumericComparativeExprNode) reflect.Value {
-	left, right := st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right)
-	isTrue := false
-	kind := left.Kind()
+// evalIfSet evaluates the left operand of a ?? operator. It returns false if the value is nil, or if it's an
+// identifier, field, chain or index expression which can't be resolved, like isset.
+func (st *Runtime) evalIfSet(node Expression) (value reflect.Value, ok bool) {
+	switch node.Type() {
+	case NodeIdentifier, NodeField, NodeChain, NodeIndexExpr:
+		defer func() {
+			if r := recover(); r != nil {
+				value, ok = reflect.Value{}, false
+			}
+		}()
+	}
+	value = st.evalPrimaryExpressionGroup(node)
+	return value, notNil(value)
+}
 
-	// if the left value is not a float and the right is, we need to promote the left value to a float before the calculation
-	// this is necessary for expressions like 4*1.23
-	needFloatPromotion := !isFloat(kind) && isFloat(right.Kind())
+func (st *Runtime) evalNumericComparativeExpression(node *NumericComparativeExprNode) reflect.Value {
+	return evalNumericComparative(node, st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right))
+}
 
-	switch node.Operator.typ {
-	case itemGreat:
-		if isInt(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Int()) > right.Float()
-			} else {
-				isTrue = left.Int() > toInt(right)
-			}
-		} else if isFloat(kind) {
-			isTrue = left.Float() > toFloat(right)
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Uint()) > right.Float()
-			} else {
-				isTrue = left.Uint() > toUint(right)
-			}
-		} else {
-			node.Left.errorf("a non numeric value in numeric comparative expression")
-		}
-	case itemGreatEquals:
-		if isInt(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Int()) >= right.Float()
-			} else {
-				isTrue = left.Int() >= toInt(right)
-			}
-		} else if isFloat(kind) {
-			isTrue = left.Float() >= toFloat(right)
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Uint()) >= right.Float()
-			} else {
-				isTrue = left.Uint() >= toUint(right)
-			}
-		} else {
-			node.Left.errorf("a non numeric value in numeric comparative expression")
-		}
-	case itemLess:
-		if isInt(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Int()) < right.Float()
-			} else {
-				isTrue = left.Int() < toInt(right)
-			}
-		} else if isFloat(kind) {
-			isTrue = left.Float() < toFloat(right)
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Uint()) < right.Float()
-			} else {
-				isTrue = left.Uint() < toUint(right)
-			}
-		} else {
-			node.Left.errorf("a non numeric value in numeric comparative expression")
-		}
-	case itemLessEquals:
-		if isInt(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Int()) <= right.Float()
-			} else {
-				isTrue = left.Int() <= toInt(right)
-			}
-		} else if isFloat(kind) {
-			isTrue = left.Float() <= toFloat(right)
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				isTrue = float64(left.Uint()) <= right.Float()
-			} else {
-				isTrue = left.Uint() <= toUint(right)
+func evalNumericComparative(node *NumericComparativeExprNode, left, right reflect.Value) reflect.Value {
+	if classifyNumber(left) == notANumber || classifyNumber(right) == notANumber {
+		if left.IsValid() && right.IsValid() {
+			if c, ok := compareOrdered(indirectInterface(left), indirectInterface(right)); ok {
+				return reflect.ValueOf(holds(node.Operator.typ, c < 0, c == 0, c > 0))
 			}
-		} else {
-			node.Left.errorf("a non numeric value in numeric comparative expression")
 		}
 	}
+	if classifyNumber(left) == notANumber {
+		node.errorf("a non numeric value in numeric comparative expression")
+	}
+	right = numericOperand(node, node.Right, "numeric comparative expression", right)
+	isTrue, err := compareNumbers(node.Operator.typ, left, right)
+	if err != nil {
+		node.error(err)
+	}
 	return reflect.ValueOf(isTrue)
 }
 
@@ -888,7 +1248,24 @@ func (st *Runtime) evalLogicalExpression(node *LogicalExprNode) reflect.Value {
 }
 
 func (st *Runtime) evalComparativeExpression(node *ComparativeExprNode) reflect.Value {
-	left, right := st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right)
+	return evalComparative(node, st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right))
+}
+
+func evalComparative(node *ComparativeExprNode, left, right reflect.Value) reflect.Value {
+	switch node.Operator.typ {
+	case itemIn:
+		found, err := contains(right, left)
+		if err != nil {
+			node.errorf("in: %v", err)
+		}
+		return reflect.ValueOf(found)
+	case itemMatch:
+		matched, err := node.match(left, right)
+		if err != nil {
+			node.errorf("=~: %v", err)
+		}
+		return reflect.ValueOf(matched)
+	}
 	equal := checkEquality(left, right)
 	if node.Operator.typ == itemNotEquals {
 		return reflect.ValueOf(!equal)
@@ -975,159 +1352,103 @@ func toFloat(v reflect.Value) float64 {
 }
 
 func (st *Runtime) evalMultiplicativeExpression(node *MultiplicativeExprNode) reflect.Value {
-	left, right := st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right)
-	kind := left.Kind()
-	// if the left value is not a float and the right is, we need to promote the left value to a float before the calculation
-	// this is necessary for expressions like 4*1.23
-	needFloatPromotion := !isFloat(kind) && isFloat(right.Kind())
-	switch node.Operator.typ {
-	case itemMul:
-		if isInt(kind) {
-			if needFloatPromotion {
-				// do the promotion and calculates
-				left = reflect.ValueOf(float64(left.Int()) * right.Float())
-			} else {
-				// do not need float promotion
-				left = reflect.ValueOf(left.Int() * toInt(right))
-			}
-		} else if isFloat(kind) {
-			left = reflect.ValueOf(left.Float() * toFloat(right))
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				left = reflect.ValueOf(float64(left.Uint()) * right.Float())
-			} else {
-				left = reflect.ValueOf(left.Uint() * toUint(right))
-			}
-		} else {
-			node.Left.errorf("a non numeric value in multiplicative expression")
-		}
-	case itemDiv:
-		if right.IsZero() {
-			node.Left.errorf("division by zero")
-		}
-		if isInt(kind) {
-			if needFloatPromotion {
-				left = reflect.ValueOf(float64(left.Int()) / right.Float())
-			} else {
-				left = reflect.ValueOf(left.Int() / toInt(right))
-			}
-		} else if isFloat(kind) {
-			left = reflect.ValueOf(left.Float() / toFloat(right))
-		} else if isUint(kind) {
-			if needFloatPromotion {
-				left = reflect.ValueOf(float64(left.Uint()) / right.Float())
-			} else {
-				left = reflect.ValueOf(left.Uint() / toUint(right))
-			}
-		} else {
-			node.Left.errorf("a non numeric value in multiplicative expression")
-		}
-	case itemMod:
-		if isInt(kind) {
-			left = reflect.ValueOf(left.Int() % toInt(right))
-		} else if isFloat(kind) {
-			left = reflect.ValueOf(int64(left.Float()) % toInt(right))
-		} else if isUint(kind) {
-			left = reflect.ValueOf(left.Uint() % toUint(right))
-		} else {
-			node.Left.errorf("a non numeric value in multiplicative expression")
+	return evalMultiplicative(node, st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right))
+}
+
+func evalMultiplicative(node *MultiplicativeExprNode, left, right reflect.Value) reflect.Value {
+	if result, ok, err := overloadedOperator(node.Operator.typ, left, right); ok {
+		if err != nil {
+			node.error(err)
 		}
+		return result
+	}
+	if classifyNumber(left) == notANumber {
+		node.errorf("a non numeric value in multiplicative expression")
+	}
+	right = numericOperand(node, node.Right, "multiplicative expression", right)
+	result, err := arithmetic(node.Operator, left, right)
+	if err != nil {
+		node.error(err)
 	}
-	return left
+	return result
 }
 
 func (st *Runtime) evalAdditiveExpression(node *AdditiveExprNode) reflect.Value {
+	var left reflect.Value
+	if node.Left != nil {
+		left = st.evalPrimaryExpressionGroup(node.Left)
+	}
+	return evalAdditive(node, left, st.evalPrimaryExpressionGroup(node.Right))
+}
+
+// evalAdditive computes the result of node; left is ignored for unary expressions (node.Left == nil).
+func evalAdditive(node *AdditiveExprNode, left, right reflect.Value) reflect.Value {
 	isAdditive := node.Operator.typ == itemAdd
 	if node.Left == nil {
-		right := st.evalPrimaryExpressionGroup(node.Right)
 		if !right.IsValid() {
 			node.errorf("right side of additive expression is invalid value")
 		}
-		kind := right.Kind()
-		// todo: optimize
-		if isInt(kind) {
-			if isAdditive {
-				return reflect.ValueOf(+right.Int())
-			} else {
-				return reflect.ValueOf(-right.Int())
-			}
-		} else if isUint(kind) {
-			if isAdditive {
-				return right
-			} else {
-				return reflect.ValueOf(-int64(right.Uint()))
-			}
-		} else if isFloat(kind) {
-			if isAdditive {
-				return reflect.ValueOf(+right.Float())
-			} else {
-				return reflect.ValueOf(-right.Float())
-			}
+		if classifyNumber(right) == notANumber {
+			node.errorf("additive expression: right side %s (%s) is not a numeric value (no left side)", node.Right, getTypeString(right))
 		}
-		node.Left.errorf("additive expression: right side %s (%s) is not a numeric value (no left side)", node.Right, getTypeString(right))
+		if isAdditive {
+			return right
+		}
+		result, err := negate(right)
+		if err != nil {
+			node.error(err)
+		}
+		return result
 	}
 
-	left, right := st.evalPrimaryExpressionGroup(node.Left), st.evalPrimaryExpressionGroup(node.Right)
 	if !left.IsValid() {
 		node.errorf("left side of additive expression is invalid value")
 	}
 	if !right.IsValid() {
 		node.errorf("right side of additive expression is invalid value")
 	}
-	kind := left.Kind()
-	// if the left value is not a float and the right is, we need to promote the left value to a float before the calculation
-	// this is necessary for expressions like 4+1.23
-	needFloatPromotion := !isFloat(kind) && kind != reflect.String && isFloat(right.Kind())
-	if needFloatPromotion {
-		if isInt(kind) {
-			if isAdditive {
-				left = reflect.ValueOf(float64(left.Int()) + right.Float())
-			} else {
-				left = reflect.ValueOf(float64(left.Int()) - right.Float())
-			}
-		} else if isUint(kind) {
-			if isAdditive {
-				left = reflect.ValueOf(float64(left.Uint()) + right.Float())
-			} else {
-				left = reflect.ValueOf(float64(left.Uint()) - right.Float())
-			}
-		} else {
-			node.Left.errorf("additive expression: left side (%s (%s) needs float promotion but neither int nor uint)", node.Left, getTypeString(left))
+	if result, ok, err := overloadedOperator(node.Operator.typ, left, right); ok {
+		if err != nil {
+			node.error(err)
 		}
-	} else {
-		if isInt(kind) {
-			if isAdditive {
-				left = reflect.ValueOf(left.Int() + toInt(right))
-			} else {
-				left = reflect.ValueOf(left.Int() - toInt(right))
-			}
-		} else if isFloat(kind) {
-			if isAdditive {
-				left = reflect.ValueOf(left.Float() + toFloat(right))
-			} else {
-				left = reflect.ValueOf(left.Float() - toFloat(right))
-			}
-		} else if isUint(kind) {
-			if isAdditive {
-				left = reflect.ValueOf(left.Uint() + toUint(right))
-			} else {
-				left = reflect.ValueOf(left.Uint() - toUint(right))
-			}
-		} else if kind == reflect.String {
-			if !isAdditive {
-				node.Right.errorf("minus signal is not allowed with strings")
-			}
-			// converts []byte (and alias types of []byte) to string
-			if right.Kind() == reflect.Slice && right.Type().Elem().Kind() == reflect.Uint8 {
-				right = right.Convert(left.Type())
-			}
-			left = reflect.ValueOf(left.String() + fmt.Sprint(right))
-		} else {
-			node.Left.errorf("additive expression: left side %s (%s) is not a numeric value", node.Left, getTypeString(left))
+		return result
+	}
+	if left.Kind() == reflect.String {
+		if !isAdditive {
+			node.Right.errorf("minus signal is not allowed with strings")
 		}
+		// converts []byte (and alias types of []byte) to string
+		if right.Kind() == reflect.Slice && right.Type().Elem().Kind() == reflect.Uint8 {
+			right = right.Convert(left.Type())
+		}
+		return reflect.ValueOf(left.String() + fmt.Sprint(right))
+	}
+	if classifyNumber(left) == notANumber {
+		node.errorf("additive expression: left side %s (%s) is not a numeric value", node.Left, getTypeString(left))
 	}
+	right = numericOperand(node, node.Right, "additive expression", right)
+	result, err := arithmetic(node.Operator, left, right)
+	if err != nil {
+		node.error(err)
+	}
+	return result
+}
 
-	return left
+// numericOperand returns the value of the right operand of an arithmetic or numeric comparative expression,
+// parsing strings as numbers.
+func numericOperand(node, operand Expression, expression string, v reflect.Value) reflect.Value {
+	if classifyNumber(v) != notANumber {
+		return v
+	}
+	if v.Kind() == reflect.String {
+		n, err := parseNumber(v.String())
+		if err != nil {
+			node.errorf("%s: %s", expression, err)
+		}
+		return n
+	}
+	node.errorf("%s: right side %s (%s) is not a numeric value", expression, operand, getTypeString(v))
+	return reflect.Value{}
 }
 
 func getTypeString(value reflect.Value) string {
@@ -1138,6 +1459,10 @@ func getTypeString(value reflect.Value) string {
 }
 
 func (st *Runtime) evalBaseExpressionGroup(node Node) reflect.Value {
+	if eval := node.compiled(); eval != nil {
+		return eval(st)
+	}
+
 	switch node.Type() {
 	case NodeNil:
 		return reflect.ValueOf(nil)
@@ -1149,7 +1474,7 @@ func (st *Runtime) evalBaseExpressionGroup(node Node) reflect.Value {
 	case NodeString:
 		return reflect.ValueOf(&node.(*StringNode).Text).Elem()
 	case NodeIdentifier:
-		resolved, err := st.resolve(node.(*IdentifierNode).Ident)
+		resolved, err := st.resolveVariable(node.(*IdentifierNode))
 		if err != nil {
 			node.error(err)
 		}
@@ -1175,17 +1500,8 @@ func (st *Runtime) evalBaseExpressionGroup(node Node) reflect.Value {
 		}
 		return resolved
 	case NodeNumber:
-		node := node.(*NumberNode)
-		if node.IsFloat {
-			return reflect.ValueOf(&node.Float64).Elem()
-		}
-
-		if node.IsInt {
-			return reflect.ValueOf(&node.Int64).Elem()
-		}
-
-		if node.IsUint {
-			return reflect.ValueOf(&node.Uint64).Elem()
+		if v := node.(*NumberNode).value(); v.IsValid() {
+			return v
 		}
 	}
 	node.errorf("unexpected node type %s in unary expression evaluating", node)
@@ -1200,8 +1516,11 @@ func (st *Runtime) evalPipeCallExpression(baseExpr reflect.Value, args CallArgs,
 	if !baseExpr.IsValid() {
 		return reflect.Value{}, errors.New("base of call expression is invalid value")
 	}
+	if baseExpr.Type() == lambdaType {
+		return st.callLambda(baseExpr.Interface().(*Lambda), args, pipedArg)
+	}
 	if funcType.AssignableTo(baseExpr.Type()) {
-		return baseExpr.Interface().(Func)(Arguments{runtime: st, args: args, pipedVal: pipedArg}), nil
+		return baseExpr.Interface().(Func).call(Arguments{runtime: st, args: args, pipedVal: pipedArg})
 	}
 
 	argValues, err := st.evaluateArgs(baseExpr.Type(), args, pipedArg)
@@ -1209,18 +1528,30 @@ func (st *Runtime) evalPipeCallExpression(baseExpr reflect.Value, args CallArgs,
 		return reflect.Value{}, fmt.Errorf("call expression: %v", err)
 	}
 
-	var returns = baseExpr.Call(argValues)
+	return callFunc(baseExpr, argValues)
+}
+
+// callFunc calls fn with args and returns its first result. Functions returning (T, error)
+// fail with the error if it's not nil.
+func callFunc(fn reflect.Value, args []reflect.Value) (reflect.Value, error) {
+	var returns = fn.Call(args)
 	if len(returns) == 0 {
 		return reflect.Value{}, nil
 	}
 
+	if last := len(returns) - 1; last > 0 && fn.Type().Out(last) == errorType {
+		if err := returns[last]; !err.IsNil() {
+			return reflect.Value{}, err.Interface().(error)
+		}
+	}
+
 	return returns[0], nil
 }
 
 func (st *Runtime) evalCommandExpression(node *CommandNode) (reflect.Value, bool) {
 	term := st.evalPrimaryExpressionGroup(node.BaseExpr)
 	if term.IsValid() && node.Exprs != nil {
-		if term.Kind() == reflect.Func {
+		if isCallable(term) {
 			if term.Type() == safeWriterType {
 				st.evalSafeWriter(term, node)
 				return reflect.Value{}, true
@@ -1231,6 +1562,9 @@ func (st *Runtime) evalCommandExpression(node *CommandNode) (reflect.Value, bool
 			}
 			return ret, false
 		}
+		if len(node.Exprs) == 0 {
+			node.errorf("command %q has arguments but is %s, not a function", node, term.Type())
+		}
 		node.Exprs[0].errorf("command %q has arguments but is %s, not a function", node.Exprs[0], term.Type())
 	}
 	return term, false
@@ -1240,12 +1574,15 @@ func (st *Runtime) evalChainNodeExpression(node *ChainNode) (reflect.Value, erro
 	resolved := st.evalPrimaryExpressionGroup(node.Node)
 
 	for i := 0; i < len(node.Field); i++ {
+		if node.optional(i) && !notNil(resolved) {
+			return reflect.Value{}, nil
+		}
 		field, err := resolveIndex(resolved, reflect.Value{}, node.Field[i])
 		if err != nil {
 			return reflect.Value{}, err
 		}
 		if !field.IsValid() {
-			if resolved.Kind() == reflect.Map && i == len(node.Field)-1 {
+			if resolved.Kind() == reflect.Map && (i == len(node.Field)-1 || node.optional(i+1)) {
 				// return reflect.Zero(resolved.Type().Elem()), nil
 				return reflect.Value{}, nil
 			}
@@ -1270,10 +1607,10 @@ func (w *escapeWriter) Write(b []byte) (int, error) {
 func (st *Runtime) evalSafeWriter(term reflect.Value, node *CommandNode, v ...reflect.Value) {
 	sw := &escapeWriter{rawWriter: st.Writer, safeWriter: term.Interface().(SafeWriter)}
 	for i := 0; i < len(v); i++ {
-		fastprinter.PrintValue(sw, v[i])
+		st.formatValue(sw, v[i])
 	}
 	for i := 0; i < len(node.Exprs); i++ {
-		fastprinter.PrintValue(sw, st.evalPrimaryExpressionGroup(node.Exprs[i]))
+		st.formatValue(sw, st.evalPrimaryExpressionGroup(node.Exprs[i]))
 	}
 }
 
@@ -1282,7 +1619,7 @@ func (st *Runtime) evalCommandPipeExpression(node *CommandNode, value reflect.Va
 	if !term.IsValid() {
 		node.errorf("base expression of command pipe node is invalid value")
 	}
-	if term.Kind() != reflect.Func {
+	if !isCallable(term) {
 		node.BaseExpr.errorf("pipe command %q must be a function, but is %s", node.BaseExpr, term.Type())
 	}
 
@@ -1316,6 +1653,15 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 	}
 	numArgsRequired := fnType.NumIn()
 	isVariadic := fnType.IsVariadic()
+
+	// named arguments are assigned to the fields of the last parameter if it's a struct of options; it may be omitted
+	hasOptions := optionsParameter(fnType) && (len(args.Named) > 0 || numArgs == numArgsRequired-1)
+	if len(args.Named) > 0 && !hasOptions {
+		return nil, fmt.Errorf("%s can't be called with named arguments: its last parameter is not a struct of options", fnType)
+	}
+	if hasOptions {
+		numArgsRequired--
+	}
 	if isVariadic {
 		numArgsRequired--
 		if numArgs < numArgsRequired {
@@ -1327,7 +1673,7 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 		}
 	}
 
-	argValues := make([]reflect.Value, numArgs)
+	argValues := make([]reflect.Value, numArgs, numArgs+1)
 	slot := 0 // index in argument values (evaluated expressions combined with piped argument if applicable)
 
 	if !args.HasPipeSlot && pipedArg != nil {
@@ -1335,10 +1681,11 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 		if !(*pipedArg).IsValid() {
 			return nil, fmt.Errorf("piped first argument for %s is not a valid value", fnType)
 		}
-		if !(*pipedArg).Type().AssignableTo(in) {
-			*pipedArg = (*pipedArg).Convert(in)
+		term, err := convertValue(*pipedArg, in)
+		if err != nil {
+			return nil, fmt.Errorf("piped first argument for %s: %v", fnType, err)
 		}
-		argValues[slot] = *pipedArg
+		argValues[slot] = term
 		slot++
 	}
 
@@ -1355,8 +1702,9 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 		if !term.IsValid() {
 			return nil, fmt.Errorf("argument for position %d in %s is not a valid value", slot, fnType)
 		}
-		if !term.Type().AssignableTo(in) {
-			term = term.Convert(in)
+		term, err := convertValue(term, in)
+		if err != nil {
+			return nil, fmt.Errorf("argument for position %d in %s: %v", slot, fnType, err)
 		}
 		argValues[slot] = term
 		i++
@@ -1375,8 +1723,9 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 			if !term.IsValid() {
 				return nil, fmt.Errorf("argument for position %d in %s is not a valid value", slot, fnType)
 			}
-			if !term.Type().AssignableTo(in) {
-				term = term.Convert(in)
+			term, err := convertValue(term, in)
+			if err != nil {
+				return nil, fmt.Errorf("argument for position %d in %s: %v", slot, fnType, err)
 			}
 			argValues[slot] = term
 			i++
@@ -1384,6 +1733,18 @@ func (st *Runtime) evaluateArgs(fnType reflect.Type, args CallArgs, pipedArg *re
 		}
 	}
 
+	if hasOptions {
+		options := reflect.Zero(fnType.In(numArgsRequired))
+		if len(args.Named) > 0 {
+			a := Arguments{runtime: st, args: args, pipedVal: pipedArg}
+			var err error
+			if options, err = a.options(options); err != nil {
+				return nil, fmt.Errorf("%s: %v", fnType, err)
+			}
+		}
+		argValues = append(argValues, options)
+	}
+
 	return argValues, nil
 }
 
@@ -1397,8 +1758,20 @@ func isFloat(kind reflect.Kind) bool {
 	return kind == reflect.Float32 || kind == reflect.Float64
 }
 
-// checkEquality of two reflect values in the semantic of the jet runtime
+// checkEquality of two reflect values in the semantic of the jet runtime, as used by ==: numbers are equal when
+// they have the same value, values with an Equal or Compare method are compared using it, arrays and structs
+// are compared element by element, and slices, maps and pointers are equal when they refer to the same data.
 func checkEquality(v1, v2 reflect.Value) bool {
+	return equal(v1, v2, false)
+}
+
+// deepEquality of two reflect values is like checkEquality, but compares the elements of slices and maps and
+// the values pointers point to.
+func deepEquality(v1, v2 reflect.Value) bool {
+	return equal(v1, v2, true)
+}
+
+func equal(v1, v2 reflect.Value, deep bool) bool {
 	v1 = indirectInterface(v1)
 	v2 = indirectInterface(v2)
 
@@ -1406,37 +1779,35 @@ func checkEquality(v1, v2 reflect.Value) bool {
 		return v1.IsValid() == v2.IsValid()
 	}
 
+	if c1, c2 := classifyNumber(v1), classifyNumber(v2); c1 != notANumber && c2 != notANumber {
+		isEqual, err := compareNumbers(itemEquals, v1, v2)
+		return err == nil && isEqual
+	}
+
+	if isEqual, ok := equalByMethod(v1, v2); ok {
+		return isEqual
+	}
+
 	v1Type := v1.Type()
 	v2Type := v2.Type()
 
 	// fast path
-	if v1Type != v2Type && !v2Type.AssignableTo(v1Type) && !v2Type.ConvertibleTo(v1Type) {
+	if v1Type != v2Type && !v2Type.AssignableTo(v1Type) && !v2Type.ConvertibleTo(v1Type) && !(deep && sameContainerKind(v1, v2)) {
 		return false
 	}
 
-	kind := v1.Kind()
-	if isInt(kind) {
-		return v1.Int() == toInt(v2)
-	}
-	if isFloat(kind) {
-		return v1.Float() == toFloat(v2)
-	}
-	if isUint(kind) {
-		return v1.Uint() == toUint(v2)
-	}
-
-	switch kind {
+	switch v1.Kind() {
 	case reflect.Bool:
 		return v1.Bool() == isTrue(v2)
 	case reflect.String:
 		return v1.String() == v2.String()
 	case reflect.Array:
 		vlen := v1.Len()
-		if vlen == v2.Len() {
+		if vlen != v2.Len() {
 			return false
 		}
 		for i := 0; i < vlen; i++ {
-			if !checkEquality(v1.Index(i), v2.Index(i)) {
+			if !equal(v1.Index(i), v2.Index(i), deep) {
 				return false
 			}
 		}
@@ -1451,12 +1822,15 @@ func checkEquality(v1, v2 reflect.Value) bool {
 			return false
 		}
 
-		if v1.CanAddr() && v2.CanAddr() && v1.Pointer() == v2.Pointer() {
+		if v1.Pointer() == v2.Pointer() {
 			return true
 		}
+		if !deep {
+			return false
+		}
 
 		for i := 0; i < vlen; i++ {
-			if !checkEquality(v1.Index(i), v2.Index(i)) {
+			if !equal(v1.Index(i), v2.Index(i), deep) {
 				return false
 			}
 		}
@@ -1465,13 +1839,19 @@ func checkEquality(v1, v2 reflect.Value) bool {
 		if v1.IsNil() || v2.IsNil() {
 			return v1.IsNil() == v2.IsNil()
 		}
-		return checkEquality(v1.Elem(), v2.Elem())
+		return equal(v1.Elem(), v2.Elem(), deep)
 	case reflect.Ptr:
-		return v1.Pointer() == v2.Pointer()
+		if v1.Pointer() == v2.Pointer() {
+			return true
+		}
+		if !deep || v1.IsNil() || v2.IsNil() {
+			return false
+		}
+		return equal(v1.Elem(), v2.Elem(), deep)
 	case reflect.Struct:
 		numField := v1.NumField()
 		for i, n := 0, numField; i < n; i++ {
-			if !checkEquality(v1.Field(i), v2.Field(i)) {
+			if !equal(v1.Field(i), v2.Field(i), deep) {
 				return false
 			}
 		}
@@ -1486,10 +1866,16 @@ func checkEquality(v1, v2 reflect.Value) bool {
 		if v1.Pointer() == v2.Pointer() {
 			return true
 		}
+		if !deep {
+			return false
+		}
+		if !v1Type.Key().AssignableTo(v2Type.Key()) {
+			return false
+		}
 		for _, k := range v1.MapKeys() {
 			val1 := v1.MapIndex(k)
 			val2 := v2.MapIndex(k)
-			if !val1.IsValid() || !val2.IsValid() || !checkEquality(v1.MapIndex(k), v2.MapIndex(k)) {
+			if !val1.IsValid() || !val2.IsValid() || !equal(val1, val2, deep) {
 				return false
 			}
 		}
@@ -1502,6 +1888,16 @@ func checkEquality(v1, v2 reflect.Value) bool {
 	}
 }
 
+// sameContainerKind reports whether v1 and v2 are both slices, both arrays or both maps, whose elements can be
+// compared by deepEquality even if their types differ.
+func sameContainerKind(v1, v2 reflect.Value) bool {
+	switch v1.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v1.Kind() == v2.Kind()
+	}
+	return false
+}
+
 func isTrue(v reflect.Value) bool {
 	return v.IsValid() && !v.IsZero()
 }
@@ -1526,6 +1922,50 @@ func castInt64(v reflect.Value) int64 {
 var cachedStructsMutex = sync.RWMutex{}
 var cachedStructsFieldIndex = map[reflect.Type]map[string][]int{}
 
+var cachedMethodsMutex = sync.RWMutex{}
+var cachedMethodIndex = map[reflect.Type]map[string]int{}
+
+// fieldIndex returns the index sequence of the exported field name of the struct type typ,
+// including fields promoted from embedded structs.
+func fieldIndex(typ reflect.Type, name string) ([]int, bool) {
+	cachedStructsMutex.RLock()
+	cache, ok := cachedStructsFieldIndex[typ]
+	cachedStructsMutex.RUnlock()
+	if !ok {
+		cachedStructsMutex.Lock()
+		if cache, ok = cachedStructsFieldIndex[typ]; !ok {
+			cache = make(map[string][]int)
+			buildCache(typ, cache, nil)
+			cachedStructsFieldIndex[typ] = cache
+		}
+		cachedStructsMutex.Unlock()
+	}
+	index, ok := cache[name]
+	return index, ok
+}
+
+// methodIndex returns the index of the exported method name in the method set of typ, to be used
+// with reflect.Value.Method(). Unlike reflect.Value.MethodByName(), it doesn't allocate once the
+// methods of typ are cached.
+func methodIndex(typ reflect.Type, name string) (int, bool) {
+	cachedMethodsMutex.RLock()
+	cache, ok := cachedMethodIndex[typ]
+	cachedMethodsMutex.RUnlock()
+	if !ok {
+		cachedMethodsMutex.Lock()
+		if cache, ok = cachedMethodIndex[typ]; !ok {
+			cache = make(map[string]int, typ.NumMethod())
+			for i := 0; i < typ.NumMethod(); i++ {
+				cache[typ.Method(i).Name] = i
+			}
+			cachedMethodIndex[typ] = cache
+		}
+		cachedMethodsMutex.Unlock()
+	}
+	index, ok := cache[name]
+	return index, ok
+}
+
 // from text/template's exec.go:
 //
 // indirect returns the item at the end of indirection, and a bool to indicate
@@ -1610,8 +2050,8 @@ func resolveIndex(v, index reflect.Value, indexAsStr string) (reflect.Value, err
 		if ptr.Kind() != reflect.Interface && ptr.Kind() != reflect.Ptr && ptr.CanAddr() {
 			ptr = ptr.Addr()
 		}
-		if method := ptr.MethodByName(indexAsStr); method.IsValid() {
-			return method, nil
+		if i, ok := methodIndex(ptr.Type(), indexAsStr); ok {
+			return ptr.Method(i), nil
 		}
 	}
 
@@ -1635,21 +2075,13 @@ func resolveIndex(v, index reflect.Value, indexAsStr string) (reflect.Value, err
 		typ := v.Type()
 		key := indexAsStr
 
-		// Fast path: use the struct cache to avoid allocations.
-		cachedStructsMutex.RLock()
-		cache, ok := cachedStructsFieldIndex[typ]
-		cachedStructsMutex.RUnlock()
-		if !ok {
-			cachedStructsMutex.Lock()
-			if cache, ok = cachedStructsFieldIndex[typ]; !ok {
-				cache = make(map[string][]int)
-				buildCache(typ, cache, nil)
-				cachedStructsFieldIndex[typ] = cache
-			}
-			cachedStructsMutex.Unlock()
+		if typ == loopType && (key == "last" || key == "Last") && v.CanAddr() {
+			// the next iteration of a range is only looked for when it's needed
+			v.Addr().Interface().(*Loop).IsLast()
 		}
 
-		if id, ok := cache[key]; ok {
+		// Fast path: use the struct cache to avoid allocations.
+		if id, ok := fieldIndex(typ, key); ok {
 			field := v.FieldByIndex(id)
 			return indirectEface(field), nil
 		}
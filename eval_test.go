@@ -16,13 +16,20 @@ package jet
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"text/template"
+	"time"
 )
 
 var (
@@ -98,6 +105,8 @@ func init() {
 	JetTestingLoader.Set("BenchCustomRender", "{{range k, v := ints(0, .N)}}{{.Field}}{{end}}")
 	JetTestingLoader.Set("BenchCallCustomFn", "{{range ints(0, .N)}}{{customFn(.)}}{{end}}")
 	JetTestingLoader.Set("BenchExecPipeline", "{{range ints(0, .N)}}{{. | customFn}}{{end}}")
+	JetTestingLoader.Set("BenchChainMethod", "{{range .}}{{.User.Profile.DisplayName()}}{{end}}")
+	JetTestingLoader.Set("BenchChainField", "{{range .}}{{.User.Profile.Name}}{{end}}")
 }
 
 func RunJetTest(t *testing.T, variables VarMap, context interface{}, testName, testContent, testExpected string) {
@@ -251,6 +260,503 @@ func TestEvalActionNode(t *testing.T) {
 	tearDown()
 }
 
+type errUser struct {
+	Name string
+}
+
+func (u *errUser) Avatar() (string, error) {
+	if u.Name == "" {
+		return "", errors.New("user has no avatar")
+	}
+	return u.Name + ".png", nil
+}
+
+func TestEvalFuncReturningError(t *testing.T) {
+	l := NewInMemLoader()
+	l.Set("avatar", `{{ .Avatar() }}`)
+	l.Set("avatar_pipe", `{{ "x" | parse }}`)
+	l.Set("avatar_try", `a{{ try }}{{ .Avatar() }}{{ catch err }}{{ err.Error() }}{{ end }}b`)
+	var set = NewSet(l, WithSafeWriter(nil))
+
+	vars := VarMap{}
+	vars.Set("parse", strconv.Atoi)
+
+	RunJetTestWithSet(t, set, nil, &errUser{Name: "mario"}, "avatar", "mario.png")
+	RunJetTestWithSet(t, set, nil, &errUser{}, "avatar_try", `aJet Runtime Error ("/avatar_try":1): user has no avatarb`)
+
+	for name, context := range map[string]interface{}{"avatar": &errUser{}, "avatar_pipe": nil} {
+		tt, err := set.GetTemplate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, context)
+		if err == nil {
+			t.Fatalf("expected %s to fail with a runtime error, but got nil", name)
+		}
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("Jet Runtime Error (%q:1): ", "/"+name)) {
+			t.Errorf("expected located runtime error, but got %q", err.Error())
+		}
+	}
+}
+
+func TestEvalMultiValueAssignment(t *testing.T) {
+	loadUser := func(name string) (*User, error) {
+		if name == "" {
+			return nil, errors.New("no name")
+		}
+		return &User{Name: name}, nil
+	}
+	vars := VarMap{}
+	vars.Set("loadUser", loadUser)
+	vars.Set("pair", func() (int, string) { return 1, "one" })
+	vars.Set("dummy", dummy)
+
+	RunJetTest(t, vars, nil, "multiValueLet", `{{ user, err := loadUser("Mario") }}{{ if err }}{{ err }}{{ else }}{{ user.Name }}{{ end }}`, "Mario")
+	RunJetTest(t, vars, nil, "multiValueLetErr", `{{ user, err := loadUser("") }}{{ if err != nil }}{{ err }}{{ else }}{{ user.Name }}{{ end }}`, "no name")
+	RunJetTest(t, vars, nil, "multiValueSet", `{{ a := 0 }}{{ b := "" }}{{ a, b = pair() }}{{ a }} {{ b }}`, "1 one")
+	RunJetTest(t, vars, nil, "multiValueDiscard", `{{ _, b := pair() }}{{ b }}`, "one")
+
+	for name, content := range map[string]string{
+		"multiValueTooMany": `{{ a, b, c := pair() }}`,
+		"multiValueSingle":  `{{ a, b := dummy("x") }}`,
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Fatalf("expected %s to fail with a runtime error, but got nil", name)
+		}
+		if !strings.Contains(err.Error(), "assignment mismatch") {
+			t.Errorf("expected runtime error about assignment mismatch, but got %q", err.Error())
+		}
+	}
+}
+
+func TestEvalArgumentConversion(t *testing.T) {
+	sum := func(numbers []int) (sum int) {
+		for _, n := range numbers {
+			sum += n
+		}
+		return
+	}
+	keys := func(m map[string]uint8) int {
+		return len(m)
+	}
+	truncate := func(s string, n int, suffix string) string {
+		if len(s) <= n {
+			return s
+		}
+		return s[:n] + suffix
+	}
+	vars := VarMap{}
+	vars.Set("repeat", strings.Repeat)
+	vars.Set("sum", sum)
+	vars.Set("keys", keys)
+	vars.Set("itoa", strconv.Itoa)
+	vars.SetFunc("truncate", NewFunc(truncate, "..."))
+	vars.SetFunc("join", NewFunc(strings.Join, ", "))
+	vars.SetFunc("sprintf", NewFunc(fmt.Sprintf))
+	vars.SetFunc("atoi", NewFunc(strconv.Atoi))
+
+	RunJetTest(t, vars, nil, "convertNumbers", `{{ repeat("ab", 3) }} {{ itoa(6 / 2) }} {{ repeat("x", "2") }}`, "ababab 3 xx")
+	RunJetTest(t, vars, nil, "convertString", `{{ repeat(42, 2) }}`, "4242")
+	RunJetTest(t, vars, nil, "convertSliceMap", `{{ sum(slice(1, 2, 3)) }} {{ keys(map("a", 1, "b", 2)) }}`, "6 2")
+	RunJetTest(t, vars, nil, "convertPiped", `{{ 3 | itoa }}`, "3")
+	RunJetTest(t, vars, nil, "convertTruncate", `{{ repeat("ab", 2.5) }} {{ truncate("Hello", 2.9) }}`, "abab He...")
+	RunJetTest(t, vars, nil, "funcDefaults", `{{ truncate("Hello World", 5) }} {{ truncate("Hello World", 5, "!") }} {{ join(slice("a", "b")) }}`, "Hello... Hello! a, b")
+	RunJetTest(t, vars, nil, "funcVariadic", `{{ sprintf("%v-%s", 1, "a") }} {{ atoi("12") + 1 }}`, "1-a 13")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"convertBadString":   {`{{ repeat("ab", "many") }}`, `argument for position 1 in func(string, int) string: cannot use "many" as an integer`},
+		"convertOverflow":    {`{{ keys(map("a", 300)) }}`, "value for key a: 300 overflows uint8"},
+		"convertElement":     {`{{ sum(slice(1, "x")) }}`, `element 1: cannot use "x" as an integer`},
+		"funcMissingArgs":    {`{{ truncate("Hello") }}`, "needs at least 2 arguments, but have 1"},
+		"funcTooManyArgs":    {`{{ truncate("Hello", 1, "", "") }}`, "needs at most 3 arguments, but have 4"},
+		"funcBadArgument":    {`{{ truncate("Hello", true) }}`, "argument for position 1 in func(string, int, string) string: cannot use bool as an integer"},
+		"funcReturningError": {`{{ atoi("x") }}`, `Jet Runtime Error ("funcReturningError":1): strconv.Atoi: parsing "x": invalid syntax`},
+		"funcPipedError":     {`{{ "x" | atoi }}`, `Jet Runtime Error ("funcPipedError":1): strconv.Atoi: parsing "x": invalid syntax`},
+		"funcAssignedError":  {`{{ n, _ := atoi("x") }}`, `Jet Runtime Error ("funcAssignedError":1): strconv.Atoi: parsing "x": invalid syntax`},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
+type celsius float32
+
+type pageOptions struct {
+	Title    string `jet:"title,required"`
+	Limit    uint8  `jet:"limit"`
+	Tags     []string
+	internal bool
+	Skipped  int `jet:"-"`
+}
+
+type pageBase struct {
+	Title string
+	Lang  string `jet:"lang"`
+}
+
+type embeddedPageOptions struct {
+	pageBase
+	Title string
+	Limit int
+}
+
+func TestArgumentsParseIntoAndBind(t *testing.T) {
+	var parsed string
+	vars := VarMap{}
+	vars.SetFunc("parse", func(a Arguments) reflect.Value {
+		var (
+			u    uint16
+			i8   int8
+			temp celsius
+			ints []int
+			m    map[string]float32
+			opts pageOptions
+			p    *int
+			s    fmt.Stringer
+		)
+		if err := a.ParseInto(&u, &i8, &temp, &ints, &m, &opts, &p, &s); err != nil {
+			a.Panicf("%v", err)
+		}
+		parsed = fmt.Sprintf("%d %d %v %v %v %+v %d %s", u, i8, temp, ints, m, opts, *p, s)
+		return reflect.Value{}
+	})
+	vars.SetFunc("bind", func(a Arguments) reflect.Value {
+		opts := pageOptions{Limit: 10}
+		if err := a.Bind(&opts); err != nil {
+			a.Panicf("%v", err)
+		}
+		parsed = fmt.Sprintf("%+v", opts)
+		return reflect.Value{}
+	})
+	vars.SetFunc("bindEmbedded", func(a Arguments) reflect.Value {
+		var opts embeddedPageOptions
+		if err := a.Bind(&opts); err != nil {
+			a.Panicf("%v", err)
+		}
+		parsed = fmt.Sprintf("%s|%s|%s|%d", opts.pageBase.Title, opts.Lang, opts.Title, opts.Limit)
+		return reflect.Value{}
+	})
+	vars.Set("stringer", &StringerType{})
+
+	RunJetTest(t, vars, nil, "parseInto", `{{ parse(65535, -128.9, 21.5, slice(1, 2.5), map("a", 1), map("title", "Home", "tags", slice("x")), 3, stringer) }}`, "")
+	if expected := "65535 -128 21.5 [1 2] map[a:1] {Title:Home Limit:0 Tags:[x] internal:false Skipped:0} 3 StringerType implements fmt.Stringer"; parsed != expected {
+		t.Errorf("expected ParseInto to produce %q, got %q", expected, parsed)
+	}
+
+	RunJetTest(t, vars, nil, "bind", `{{ bind("Home") }}`, "")
+	if expected := "{Title:Home Limit:10 Tags:[] internal:false Skipped:0}"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+	RunJetTest(t, vars, nil, "bindAll", `{{ bind("Home", 5, slice("a", "b")) }}`, "")
+	if expected := "{Title:Home Limit:5 Tags:[a b] internal:false Skipped:0}"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+	RunJetTest(t, vars, nil, "bindEmbedded", `{{ bindEmbedded("en", "Home", limit: 2) }}`, "")
+	if expected := "|en|Home|2"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+	RunJetTest(t, vars, nil, "bindEmbeddedNamed", `{{ bindEmbedded(LANG: "it", title: "Casa") }}`, "")
+	if expected := "|it|Casa|0"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+
+	for name, test := range map[string]struct{ content, err string }{
+		"parseIntoOverflow": {`{{ parse(65536, 0, 0, slice(), map(), map(), 1, stringer) }}`, "could not parse argument at position 0 into *uint16: 65536 overflows uint16"},
+		"parseIntoNegative": {`{{ parse(-1, 0, 0, slice(), map(), map(), 1, stringer) }}`, "could not parse argument at position 0 into *uint16: -1 is negative"},
+		"parseIntoElement":  {`{{ parse(1, 0, 0, slice(1, "a"), map(), map(), 1, stringer) }}`, `could not parse argument at position 3 into *[]int: element 1: cannot use "a" as an integer`},
+		"parseIntoStruct":   {`{{ parse(1, 0, 0, slice(), map(), map(), 1, stringer) }}`, `could not parse argument at position 5 into *jet.pageOptions: missing required key "title"`},
+		"parseIntoIface":    {`{{ parse(1, 0, 0, slice(), map(), map("title", ""), 1, 2) }}`, "could not parse argument at position 7 into *fmt.Stringer: cannot use float64 as fmt.Stringer"},
+		"bindRequired":      {`{{ bind() }}`, `missing required argument "title" at position 0`},
+		"bindTooMany":       {`{{ bind("a", 1, slice(), 4) }}`, "have 4 arguments, but only 3 fields to bind to in jet.pageOptions"},
+		"bindConversion":    {`{{ bind("a", 1000) }}`, `could not parse argument "limit" at position 1: 1000 overflows uint8`},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
+type moneyOptions struct {
+	Symbol   string
+	Decimals int
+	Grouping bool `jet:"group"`
+}
+
+func TestEvalNamedArguments(t *testing.T) {
+	var parsed string
+	vars := VarMap{}
+	vars.SetFunc("named", func(a Arguments) reflect.Value {
+		return reflect.ValueOf(fmt.Sprintf("%v %v %v %v", a.NumOfArguments(), a.Get(0), a.Named("sep"), a.NamedArguments()))
+	})
+	vars.SetFunc("bind", func(a Arguments) reflect.Value {
+		opts := pageOptions{Limit: 10}
+		if err := a.Bind(&opts); err != nil {
+			a.Panicf("%v", err)
+		}
+		parsed = fmt.Sprintf("%+v", opts)
+		return reflect.Value{}
+	})
+	money := func(amount float64, opts moneyOptions) string {
+		s := strconv.FormatFloat(amount, 'f', opts.Decimals, 64)
+		if opts.Grouping {
+			s = "~" + s
+		}
+		return opts.Symbol + s
+	}
+	vars.Set("money", money)
+	vars.Set("moneyPtr", func(amount float64, opts *moneyOptions) string {
+		if opts == nil {
+			return "nil"
+		}
+		return money(amount, *opts)
+	})
+	vars.Set("moneyFunc", NewFunc(money))
+	vars.Set("moneyDefault", NewFunc(money, moneyOptions{Symbol: "$", Decimals: 2}))
+	vars.Set("join", strings.Join)
+	vars.Set("repeatSep", func(n int, opts struct{ Sep string }) string {
+		return strings.Repeat("x"+opts.Sep, n)
+	})
+	vars.Set("date", time.Date)
+	vars.Set("utc", time.UTC)
+	vars.Set("since", time.Since)
+
+	RunJetTest(t, vars, nil, "namedArguments_Func", `{{ named("a", sep: ", ", limit: 1 + 1) }}`, "1 a ,  [sep limit]")
+	RunJetTest(t, vars, nil, "namedArguments_FuncMissing", `{{ named("a") }}`, "1 a <invalid reflect.Value> []")
+	RunJetTest(t, vars, nil, "namedArguments_Piped", `{{ "a" | named(sep: "-") }}`, "1 a - [sep]")
+	RunJetTest(t, vars, nil, "namedArguments_Prefix", `{{ named: "a", sep: "/" }}`, "1 a / [sep]")
+	RunJetTest(t, vars, nil, "namedArguments_Struct", `{{ money(1234.5, symbol: "€", decimals: 2, group: true) }}`, "€~1234.50")
+	RunJetTest(t, vars, nil, "namedArguments_AnonymousStruct", `{{ repeatSep(2, sep: "-") }} {{ repeatSep(1) }}`, "x-x- x")
+	RunJetTest(t, vars, nil, "namedArguments_StructOmitted", `{{ money(1234.5) }} {{ 2 | money }}`, "1234 2")
+	RunJetTest(t, vars, nil, "namedArguments_StructPositional", `{{ money(1, map("Symbol", "£")) }}`, "£1")
+	RunJetTest(t, vars, nil, "namedArguments_StructPointer", `{{ moneyPtr(1, decimals: 1) }} {{ moneyPtr(1) }}`, "1.0 nil")
+	RunJetTest(t, vars, nil, "namedArguments_NewFunc", `{{ moneyFunc(1.5, decimals: 1) }} {{ moneyFunc(1.5) }}`, "1.5 2")
+	RunJetTest(t, vars, nil, "namedArguments_NewFuncDefault", `{{ moneyDefault(1) }} {{ moneyDefault(1, decimals: 0) }}`, "$1.00 $1")
+	RunJetTest(t, vars, nil, "namedArguments_Variables", `{{ d := 3 }}{{ if true }}{{ money(1, decimals: d) }}{{ end }}`, "1.000")
+
+	RunJetTest(t, vars, nil, "namedArguments_Bind", `{{ bind("Home", tags: slice("a"), limit: 3) }}`, "")
+	if expected := "{Title:Home Limit:3 Tags:[a] internal:false Skipped:0}"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+	RunJetTest(t, vars, nil, "namedArguments_BindRequired", `{{ bind(title: "Named") }}`, "")
+	if expected := "{Title:Named Limit:10 Tags:[] internal:false Skipped:0}"; parsed != expected {
+		t.Errorf("expected Bind to produce %q, got %q", expected, parsed)
+	}
+
+	for name, test := range map[string]struct{ content, err string }{
+		"bindUnknown":      {`{{ bind("a", skipped: 1) }}`, "unknown argument skipped for jet.pageOptions"},
+		"bindTwice":        {`{{ bind("a", title: "b") }}`, "argument title is passed both by position and by name"},
+		"bindRequired":     {`{{ bind(limit: 1) }}`, `missing required argument "title" at position 0`},
+		"structUnknown":    {`{{ money(1, currency: "€") }}`, "unknown argument currency for jet.moneyOptions"},
+		"structConversion": {`{{ money(1, decimals: "two") }}`, `could not parse argument decimals: cannot use "two" as an integer`},
+		"notStruct":        {`{{ join(slice("a"), sep: ",") }}`, "func([]string, string) string can't be called with named arguments: its last parameter is not a struct of options"},
+		"notOptions":       {`{{ date(2020, 1, 1, 0, 0, 0, 0, utc, year: 1) }}`, "can't be called with named arguments: its last parameter is not a struct of options"},
+		"notOptionsArity":  {`{{ date(2020, 1, 1, 0, 0, 0, 0) }}`, "needs 8 arguments, but have 7"},
+		"plainStructArity": {`{{ since() }}`, "needs 1 arguments, but have 0"},
+		"newFuncUnknown":   {`{{ moneyFunc(1, currency: "€") }}`, "unknown argument currency for jet.moneyOptions"},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
+type product struct {
+	Name  string
+	Price float64
+}
+
+func TestEvalLambdas(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("products", []product{{"pen", 2}, {"book", 12}, {"lamp", 30}, {"mug", 12}})
+	vars.Set("stock", map[string]int{"pen": 0, "book": 3})
+	vars.SetFunc("apply", func(a Arguments) reflect.Value {
+		return a.Call(a.Get(0), a.Get(1))
+	})
+	vars.Set("applyGo", func(fn func(int) int, v int) int {
+		return fn(v)
+	})
+	vars.SetFunc("parsed", func(a Arguments) reflect.Value {
+		var fn func(string) string
+		if err := a.ParseInto(&fn); err != nil {
+			a.Panicf("%v", err)
+		}
+		return reflect.ValueOf(fn("go"))
+	})
+
+	RunJetTest(t, vars, nil, "lambda_Call", `{{ double := x => x * 2 }}{{ double(3) }} {{ double: 4 }} {{ 5 | double }}`, "6 8 10")
+	RunJetTest(t, vars, nil, "lambda_Params", `{{ add := (a, b) => a + b }}{{ none := () => "none" }}{{ add(1, 2) }} {{ none() }}`, "3 none")
+	RunJetTest(t, vars, nil, "lambda_Capture", `{{ n := 10 }}{{ add := x => x + n }}{{ n = 20 }}{{ add(1) }}`, "21")
+	RunJetTest(t, vars, nil, "lambda_CaptureScope", `{{ range _, p := products }}{{ f := x => p.Name + x }}{{ if p.Price > 20 }}{{ f("!") }}{{ end }}{{ end }}`, "lamp!")
+	RunJetTest(t, vars, nil, "lambda_Context", `{{ with "ctx" }}{{ f := () => . }}{{ end }}{{ with f := () => . }}{{ f() }}{{ end }}`, "")
+	RunJetTest(t, vars, nil, "lambda_Shadowing", `{{ x := 1 }}{{ f := x => x * 10 }}{{ f(2) }} {{ x }}`, "20 1")
+	RunJetTest(t, vars, nil, "lambda_Recursive", `{{ fact := n => n <= 1 ? 1 : n * fact(n - 1) }}{{ fact(5) }}`, "120")
+	RunJetTest(t, vars, nil, "lambda_Filter", `{{ range _, p := filter(products, p => p.Price > 10) }}{{ p.Name }} {{ end }}`, "book lamp mug ")
+	RunJetTest(t, vars, nil, "lambda_FilterMap", `{{ len(filter(stock, n => n > 0)) }}`, "1")
+	RunJetTest(t, vars, nil, "lambda_Map", `{{ map(products, p => p.Name) }} {{ map(stock, n => n + 1) }} {{ map(slice(1, 2), x => x * 2) }}`, "[pen book lamp mug] map[book:4 pen:1] [2 4]")
+	RunJetTest(t, vars, nil, "lambda_MapBuiltin", `{{ map("a", 1) }} {{ map("f", x => x)["f"](1) }}`, "map[a:1] 1")
+	RunJetTest(t, vars, nil, "lambda_SortBy", `{{ range _, p := sortBy(products, p => -p.Price) }}{{ p.Name }} {{ end }}`, "lamp book mug pen ")
+	RunJetTest(t, vars, nil, "lambda_SortByString", `{{ sortBy(slice("b", "c", "a"), s => s) }}`, "[a b c]")
+	RunJetTest(t, vars, nil, "lambda_Arguments", `{{ apply(x => x + 1, 1) }} {{ apply(upper, "a") }}`, "2 A")
+	RunJetTest(t, vars, nil, "lambda_GoFunc", `{{ applyGo(x => x * 3, 2) }} {{ parsed(s => s + "!") }}`, "6 go!")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"arity":       {`{{ f := (a, b) => a }}{{ f(1) }}`, "lambda (a, b) => a called with 1 arguments, but has 2 parameters"},
+		"named":       {`{{ f := x => x }}{{ f(x: 1) }}`, "lambda x => x can't be called with named arguments"},
+		"filterArity": {`{{ filter(products, (a, b) => a) }}`, "lambda (a, b) => a called with 1 arguments, but has 2 parameters"},
+		"sortByKeys":  {`{{ sortBy(slice(1, "a"), x => x) }}`, "sortBy(): can't compare"},
+		"notFunc":     {`{{ filter(products, 1) }}`, "float64 is not a function"},
+		"goFuncArity": {`{{ applyGo((a, b) => a, 1) }}`, "cannot use lambda (a, b) => a as func(int) int: the lambda has 2 parameters"},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+
+	var kept *Lambda
+	vars.Set("keep", func(l *Lambda) { kept = l })
+	RunJetTest(t, vars, nil, "lambda_Kept", `{{ keep(x => x) }}`, "")
+	func() {
+		defer func() {
+			err, ok := recover().(error)
+			if !ok || !strings.Contains(err.Error(), "lambda x => x called after the execution of its template finished") {
+				t.Errorf("expected calling a lambda after its execution finished to fail, but got %v", err)
+			}
+		}()
+		kept.Call(reflect.ValueOf(1))
+	}()
+}
+
+type account struct {
+	Owner *User
+	Meta  map[string]interface{}
+}
+
+func TestEvalCoalesceAndOptionalChaining(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("full", &account{Owner: &User{Name: "José"}, Meta: map[string]interface{}{"plan": map[string]string{"name": "pro"}}})
+	vars.Set("empty", &account{})
+	vars.Set("none", (*account)(nil))
+	vars.Set("zero", 0)
+	vars.Set("names", map[string]string{"a": "Alpha"})
+
+	RunJetTest(t, vars, nil, "coalesce_Unset", `{{ missing ?? "default" }}`, "default")
+	RunJetTest(t, vars, nil, "coalesce_Nil", `{{ nil ?? "default" }} {{ none ?? "default" }}`, "default default")
+	RunJetTest(t, vars, nil, "coalesce_Set", `{{ zero ?? 1 }} {{ "" ?? "x" }}`, "0 ")
+	RunJetTest(t, vars, nil, "coalesce_Chain", `{{ missing ?? nil ?? "last" }}`, "last")
+	RunJetTest(t, vars, nil, "coalesce_Index", `{{ names["b"] ?? "none" }} {{ names["a"] ?? "none" }}`, "none Alpha")
+	RunJetTest(t, vars, nil, "coalesce_Field", `{{ empty.Owner.Name ?? "anonymous" }}`, "anonymous")
+	RunJetTest(t, vars, nil, "coalesce_Precedence", `{{ missing ?? false || true }} {{ missing ?? 1 ? "yes" : "no" }}`, "true yes")
+	RunJetTest(t, vars, nil, "coalesce_Isset", `{{ isset(missing ?? zero) }} {{ isset(missing ?? nil) }}`, "true false")
+	RunJetTest(t, vars, &account{}, "coalesce_Context", `{{ .Owner?.Name ?? "none" }}`, "none")
+
+	RunJetTest(t, vars, nil, "optional_Set", `{{ full.Owner?.Name }} {{ full?.Owner?.Name }}`, "José José")
+	RunJetTest(t, vars, nil, "optional_NilPointer", `{{ empty.Owner?.Name }}|{{ none?.Owner?.Name }}`, "|")
+	RunJetTest(t, vars, nil, "optional_Map", `{{ full.Meta?.plan?.name }}|{{ empty.Meta?.plan?.name }}|{{ full.Meta.other?.name }}`, "pro||")
+	RunJetTest(t, vars, nil, "optional_Coalesce", `{{ empty.Owner?.Name ?? "anonymous" }}`, "anonymous")
+	RunJetTest(t, vars, map[string]string{"Name": "ctx"}, "optional_Ternary", `{{ full ?.Name : "x" }} {{ none ?.Name : "x" }}`, "ctx x")
+	RunJetTest(t, vars, nil, "optional_Isset", `{{ isset(full.Owner?.Name) }} {{ isset(empty.Owner?.Name) }}`, "true false")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"notOptional":   {`{{ empty.Owner.Name }}`, "nil pointer evaluating"},
+		"afterOptional": {`{{ full.Meta?.other.name }}`, "there is no field or method 'other'"},
+		"coalesceRight": {`{{ missing ?? alsoMissing }}`, `identifier "alsoMissing" not available`},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
+type role string
+
+func TestEvalMembershipAndMatch(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("roles", []string{"admin", "editor"})
+	vars.Set("ids", []int64{1, 2, 3})
+	vars.Set("typedRoles", map[role]bool{"admin": true})
+	vars.Set("scores", map[int]int{7: 1})
+	vars.Set("nilMap", map[string]int(nil))
+	vars.Set("email", "jose@example.com")
+	vars.Set("re", regexp.MustCompile(`^\d+$`))
+
+	RunJetTest(t, vars, nil, "in_Slice", `{{ "admin" in roles }} {{ "guest" in roles }} {{ 2 in ids }} {{ 2.0 in ids }}`, "true false true true")
+	RunJetTest(t, vars, nil, "in_Map", `{{ "admin" in typedRoles }} {{ "guest" in typedRoles }} {{ 7 in scores }} {{ 7.5 in scores }}`, "true false true false")
+	RunJetTest(t, vars, nil, "in_String", `{{ "example" in email }} {{ "@" in "abc" }}`, "true false")
+	RunJetTest(t, vars, nil, "in_Nil", `{{ "a" in nilMap }} {{ "a" in nil }}`, "false false")
+	RunJetTest(t, vars, nil, "in_Precedence", `{{ "admin" in roles && !("guest" in roles) }} {{ 1 + 1 in ids }}`, "true true")
+	RunJetTest(t, vars, nil, "match_String", `{{ email =~ "@example\\.com$" }} {{ "abc" =~ "^b" }}`, "true false")
+	RunJetTest(t, vars, nil, "match_Regexp", `{{ "123" =~ re }} {{ "12a" =~ re }}`, "true false")
+	RunJetTest(t, vars, nil, "match_Dynamic", `{{ range _, p := slice("^a", "^b", "^a") }}{{ "abc" =~ p }} {{ end }}`, "true false true ")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"inStringType":    {`{{ 1 in email }}`, "in: can't look for float64 in a string"},
+		"inMapKeyType":    {`{{ 1 in typedRoles }}`, "in: can't look for float64 in the keys of map[jet.role]bool"},
+		"inNotCollection": {`{{ 1 in 2 }}`, "in: can't look for a value in float64: not a string, slice, array or map"},
+		"matchNotString":  {`{{ 1 =~ "1" }}`, "=~: can't match float64 against a regular expression: not a string"},
+		"matchPattern":    {`{{ "a" =~ 1 }}`, "=~: can't use float64 as regular expression: not a string"},
+		"matchInvalid":    {`{{ "a" =~ "(" }}`, "=~: invalid regular expression: error parsing regexp"},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
 func TestEvalIfNode(t *testing.T) {
 	var data = make(VarMap)
 	data.Set("lower", strings.ToLower)
@@ -269,6 +775,77 @@ func TestEvalIfNode(t *testing.T) {
 
 }
 
+func TestEvalWithNode(t *testing.T) {
+	type address struct{ City, Street string }
+	type customer struct {
+		Name    string
+		Address *address
+	}
+	var data = make(VarMap)
+	data.Set("customer", customer{Name: "José", Address: &address{City: "Lisbon", Street: "Rua Augusta"}})
+	data.Set("nobody", customer{Name: "Nobody"})
+	data.Set("empty", "")
+
+	RunJetTest(t, data, nil, "withNode_simple", `{{with customer.Address}}{{.Street}}, {{.City}}{{end}}`, `Rua Augusta, Lisbon`)
+	RunJetTest(t, data, nil, "withNode_else", `{{with nobody.Address}}{{.City}}{{else}}no address for {{nobody.Name}}{{end}}`, `no address for Nobody`)
+	RunJetTest(t, data, nil, "withNode_empty_string", `{{with empty}}[{{.}}]{{else}}empty{{end}}`, `empty`)
+	RunJetTest(t, data, "ctx", "withNode_context_restored", `{{with customer.Name}}{{.}}{{end}} {{.}}`, `José ctx`)
+	RunJetTest(t, data, nil, "withNode_let", `{{x := "outer"}}{{with x := customer.Address}}{{x.City}} {{.Street}}{{end}} {{x}}`, `Lisbon Rua Augusta outer`)
+	RunJetTest(t, data, nil, "withNode_let_else", `{{with a := nobody.Address}}{{a.City}}{{else}}{{!a}}{{end}}`, `true`)
+	RunJetTest(t, data, nil, "withNode_set", `{{a := nil}}{{with a = customer.Address}}{{end}}{{a.City}}`, `Lisbon`)
+	RunJetTest(t, data, nil, "withNode_nested", `{{with customer}}{{with .Address}}{{.City}}{{end}}{{end}}`, `Lisbon`)
+}
+
+func TestEvalSwitchNode(t *testing.T) {
+	var data = make(VarMap)
+	data.Set("n", 2)
+	data.Set("name", "jet")
+	data.Set("items", []string{"a", "b"})
+	var calls int
+	data.Set("next", func() int {
+		calls++
+		return calls
+	})
+
+	RunJetTest(t, data, nil, "switchNode_simple", `{{switch name}}{{case "go"}}go{{case "jet"}}jet{{end}}`, `jet`)
+	RunJetTest(t, data, nil, "switchNode_multiple_values", `{{switch n}}{{case 0}}none{{case 1, 2, 3}}few{{default}}many{{end}}`, `few`)
+	RunJetTest(t, data, nil, "switchNode_default", `{{switch n}}{{case 1}}one{{default}}other{{case 3}}three{{end}}`, `other`)
+	RunJetTest(t, data, nil, "switchNode_no_match", `[{{switch n}}{{case 1}}one{{end}}]`, `[]`)
+	RunJetTest(t, data, nil, "switchNode_whitespace", `{{switch n}}
+	{{case 2}}two{{end}}`, `two`)
+	RunJetTest(t, data, nil, "switchNode_numbers", `{{switch n}}{{case 2.0}}float{{end}} {{switch n * 1.5}}{{case 3}}int{{end}}`, `float int`)
+	RunJetTest(t, data, nil, "switchNode_first_match", `{{switch n}}{{case 2}}first{{case 2}}second{{end}}`, `first`)
+	RunJetTest(t, data, nil, "switchNode_let", `{{x := "outer"}}{{switch x := name + "!"; x}}{{case "jet!"}}{{x}}{{end}} {{x}}`, `jet! outer`)
+	RunJetTest(t, data, nil, "switchNode_set", `{{x := ""}}{{switch x = name; len(x)}}{{case 3}}{{x}}{{end}}`, `jet`)
+	RunJetTest(t, data, nil, "switchNode_in_range", `{{range items}}{{switch .}}{{case "a"}}A{{default}}{{.}}{{end}}{{end}}`, `Ab`)
+	RunJetTest(t, data, nil, "switchNode_evaluated_once", `{{switch next()}}{{case 0}}zero{{case 2}}two{{case 1}}one{{end}}`, `one`)
+}
+
+func TestEvalVariableScopes(t *testing.T) {
+	var data = make(VarMap)
+	data.Set("items", []string{"a", "b"})
+	data.Set("external", "ext")
+	data.SetFunc("setVar", func(a Arguments) reflect.Value {
+		a.runtime.Let(a.Get(0).String(), a.Get(1).Interface())
+		return reflect.Value{}
+	})
+
+	RunJetTest(t, data, nil, "Scopes_Shadowing", `{{ x := 1 }}{{ if true }}{{ x }}{{ x := 2 }}{{ x }}{{ end }}{{ x }}`, "121")
+	RunJetTest(t, data, nil, "Scopes_Redeclare", `{{ x := 1 }}{{ x := x + 1 }}{{ x }}`, "2")
+	RunJetTest(t, data, nil, "Scopes_AssignOuter", `{{ n := 0 }}{{ range items }}{{ n = n + 1 }}{{ end }}{{ n }}`, "2")
+	RunJetTest(t, data, nil, "Scopes_RangeVars", `{{ p := "-" }}{{ range i, v := items }}{{ p }}{{ i }}{{ v }}{{ end }}{{ range _, v := items }}{{ v }}{{ end }}`, "-0a-1bab")
+	RunJetTest(t, data, nil, "Scopes_IfLet", `{{ x := "outer" }}{{ if x := x + "!"; x != "" }}{{ x }}{{ end }} {{ x }}`, "outer! outer")
+	RunJetTest(t, data, nil, "Scopes_External", `{{ external }}{{ x := external }}{{ x }}`, "extext")
+	RunJetTest(t, data, nil, "Scopes_GoLet", `{{ setVar("y", "go") }}{{ y }}{{ if true }}{{ setVar("z", 1) }}{{ z }}{{ end }}`, "go1")
+	RunJetTest(t, data, nil, "Scopes_Catch", `{{ e := "outer" }}{{ try }}{{ if x := 1; true }}{{ undefined }}{{ end }}{{ catch e }}{{ e != "outer" }}{{ end }} {{ e }}`, "true outer")
+
+	// blocks and included templates see the variables of the template yielding or including them
+	JetTestingLoader.Set("Scopes_Included", `{{ v }}{{ if true }}{{ v := "inner" }}{{ v }}{{ end }}`)
+	RunJetTest(t, data, nil, "Scopes_Include", `{{ v := "outer" }}{{ include "Scopes_Included" }} {{ v }}`, "outerinner outer")
+	RunJetTest(t, data, nil, "Scopes_Blocks", `{{ outer := "!" }}{{ block b(p="default") }}{{ p }}{{ outer }}{{ end }}{{ if true }}{{ outer := "?" }}{{ yield b(p="param") }}{{ end }}`, "default!param?")
+	RunJetTest(t, data, nil, "Scopes_Content", `{{ x := "x" }}{{ block wrap() }}[{{ yield content }}]{{ end }}{{ yield wrap() content }}{{ x }}{{ y := "y" }}{{ y }}{{ end }}`, "[][xy]")
+}
+
 func TestEvalBlockYieldIncludeNode(t *testing.T) {
 	var data = make(VarMap)
 
@@ -344,6 +921,94 @@ func TestEvalRangeNode(t *testing.T) {
 	RunJetTest(t, data, nil, "Range_ExpressionValueIf", `{{range i, user:=users}}<h1>{{if i == 0 || i == 2}}{{i}}: {{end}}{{user.Name}}<small>{{user.Email}}</small></h1>{{end}}`, resultString2)
 }
 
+func TestEvalRangeBreakContinue(t *testing.T) {
+	var data = make(VarMap)
+	data.Set("s", []int{1, 2, 3, 4, 5})
+	data.Set("m", map[string]int{"a": 1})
+	c := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		c <- i
+	}
+	close(c)
+	data.Set("c", c)
+
+	RunJetTest(t, data, nil, "Range_Break", `{{range _, v := s}}{{if v == 3}}{{break}}{{end}}{{v}}{{end}} after`, "12 after")
+	RunJetTest(t, data, nil, "Range_Continue", `{{range _, v := s}}{{if v % 2 == 0}}{{continue}}{{end}}{{v}}{{end}}`, "135")
+	RunJetTest(t, data, nil, "Range_BreakEndsList", `{{range s}}a{{break}}b{{end}}c`, "ac")
+	RunJetTest(t, data, nil, "Range_BreakNested", `{{range _, i := s}}{{range _, j := s}}{{if j > i}}{{break}}{{end}}{{j}}{{end}};{{if i == 3}}{{break}}{{end}}{{end}}`, "1;12;123;")
+	RunJetTest(t, data, nil, "Range_ContinueInSwitch", `{{range _, v := s}}{{switch v}}{{case 2, 4}}{{continue}}{{end}}{{v}}{{end}}`, "135")
+	RunJetTest(t, data, nil, "Range_BreakInTry", `{{range _, v := s}}{{try}}{{v}}{{if v == 2}}{{break}}{{end}}{{end}}{{end}}`, "12")
+	RunJetTest(t, data, nil, "Range_BreakScope", `{{x := 0}}{{range _, v := s}}{{y := v}}{{if y > 2}}{{break}}{{end}}{{x = y}}{{end}}{{x}}`, "2")
+	RunJetTest(t, data, nil, "Range_BreakChannel", `{{range v := c}}{{if v == 2}}{{break}}{{end}}{{v}}{{end}}`, "01")
+	RunJetTest(t, data, nil, "Range_BreakReleasesRanger", `{{range s}}{{break}}{{end}}{{range k := m}}{{k}}{{end}}{{range _, v := s}}{{v}}{{end}}`, "a12345")
+	RunJetTest(t, data, nil, "Range_ContinueLast", `{{range _, v := s}}{{v}}{{continue}}{{end}}`, "12345")
+}
+
+func TestEvalRangeLoop(t *testing.T) {
+	var data = make(VarMap)
+	data.Set("s", []string{"a", "b", "c"})
+	data.Set("m", map[string]int{"a": 1})
+	c := make(chan string, 3)
+	c <- "x"
+	c <- "y"
+	c <- "z"
+	close(c)
+	data.Set("c", c)
+	data.Set("cu", &customTestRanger{data: []string{"asd", "foo", "bar"}})
+
+	RunJetTest(t, data, nil, "Loop_Index", `{{range s}}{{loop.index}}{{loop.index1}}{{.}} {{end}}`, "01a 12b 23c ")
+	RunJetTest(t, data, nil, "Loop_FirstLast", `{{range s}}{{if !loop.first}}, {{end}}{{.}}{{if loop.last}}.{{end}}{{end}}`, "a, b, c.")
+	RunJetTest(t, data, nil, "Loop_OddEven", `{{range s}}{{loop.odd ? "odd" : "even"}}{{loop.even}} {{end}}`, "oddfalse eventrue oddfalse ")
+	RunJetTest(t, data, nil, "Loop_Length", `{{range s}}{{loop.length}}{{end}} {{range m}}{{loop.length}}{{end}} {{range ints(0, 2)}}{{loop.length}}{{end}}`, "333 1 22")
+	RunJetTest(t, data, nil, "Loop_Channel", `{{range c}}{{.}}{{loop.length}}{{if loop.last}}!{{end}}{{end}}`, "x-1y-1z-1!")
+	RunJetTest(t, data, nil, "Loop_CustomRanger", `{{range cu}}{{if loop.last}}{{.}}{{end}}{{end}}`, "bar")
+	RunJetTest(t, data, nil, "Loop_Nested", `{{range _, x := s}}{{range m}}{{loop.depth}}{{loop.parent.index}}{{x}}{{if loop.parent.last}}.{{end}} {{end}}{{end}}`, "20a 21b 22c. ")
+	RunJetTest(t, data, nil, "Loop_Break", `{{range s}}{{.}}{{if loop.index == 1}}{{break}}{{end}}{{end}}`, "ab")
+	RunJetTest(t, data, nil, "Loop_Continue", `{{range s}}{{if loop.first}}{{continue}}{{end}}{{.}}{{loop.last}}{{end}}`, "bfalsectrue")
+	JetTestingLoader.Set("Loop_Included", `{{loop.index1}}{{.}}`)
+	RunJetTest(t, data, nil, "Loop_Include", `{{range s}}{{include "Loop_Included" .}}{{end}}`, "1a2b3c")
+	RunJetTest(t, data, nil, "Loop_Yield", `{{range s}}{{block item()}}{{loop.index1}}{{.}}{{end}}{{end}} {{range s}}{{yield item()}}{{end}}`, "1a2b3c 1a2b3c")
+	RunJetTest(t, data, nil, "Loop_Shadowed", `{{range _, loop := s}}{{loop}}{{end}}`, "abc")
+	RunJetTest(t, data, nil, "Loop_Restored", `{{range s}}{{range m}}{{end}}{{loop.index}}{{end}}`, "012")
+	JetTestingLoader.Set("Loop_Executed", `{{return loop.index1}}`)
+	RunJetTest(t, data, nil, "Loop_Exec", `{{range s}}{{exec("Loop_Executed")}}{{end}}`, "123")
+
+	// the next iteration is only fetched when reading loop.last
+	open := make(chan string, 1)
+	open <- "x"
+	data.Set("open", open)
+	data.Set("buf", &bufferTestRanger{data: []string{"a", "b", "c"}})
+	RunJetTest(t, data, nil, "Loop_NoLookAhead", `{{range open}}{{loop.index}}{{.}}{{break}}{{end}}`, "0x")
+	RunJetTest(t, data, nil, "Loop_Buffer", `{{range buf}}{{loop.index}}{{.}}{{end}}`, "0a1b2c")
+	// reading loop.last fetches the next value, which overwrites the buffer of the ranger
+	RunJetTest(t, data, nil, "Loop_BufferLast", `{{range buf}}{{loop.last}}{{.}}{{end}}`, "falsebfalsectruec")
+
+	set := NewSet(NewInMemLoader(), WithSafeWriter(nil))
+	set.AddGlobal("loop", "global")
+	tt, err := set.parse("Loop_Global", `{{range s}}{{loop}}{{end}}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunJetTestWithTemplate(t, tt, data, nil, "globalglobalglobal")
+}
+
+func TestEvalStatementKeywordsAsNames(t *testing.T) {
+	var data = make(VarMap)
+	data.Set("switch", "s")
+	data.Set("case", "c")
+	data.Set("default", "d")
+	data.Set("with", "w")
+	data.Set("break", "b")
+	data.Set("items", []int{1, 2, 3})
+	data.Set("in", []int{1, 2})
+
+	RunJetTest(t, data, nil, "KeywordNames", `{{ upper(default) }}{{ case + switch }}{{ with }}{{ x := break }}{{ x }}{{ 1 in in }}`, "Dcswbtrue")
+	RunJetTest(t, data, nil, "KeywordNames_Default", `{{ default }}{{ default := 3 }}{{ default }}{{ switch 1 }}{{ default }}x{{ end }}`, "d3x")
+	RunJetTest(t, data, nil, "KeywordNames_Statements", `{{ switch case }}{{ case "c" }}{{ with with }}{{ . }}{{ end }}{{ default }}{{ end }}`, "w")
+	RunJetTest(t, data, nil, "KeywordNames_Range", `{{ range _, x := items }}{{ if x == 1 }}{{ continue }}{{ end }}{{ x }}{{ break }}{{ end }}`, "2")
+	RunJetTest(t, data, nil, "KeywordNames_In", `{{ range _, x := in }}{{ x in in ? x : "" }}{{ end }}{{ len(in) }}`, "122")
+}
+
 func TestEvalDefaultFuncs(t *testing.T) {
 	RunJetTest(t, nil, nil, "DefaultFuncs_safeHtml", `<h1>{{"<h1>Hello Buddy!</h1>" |safeHtml}}</h1>`, `<h1>&lt;h1&gt;Hello Buddy!&lt;/h1&gt;</h1>`)
 	RunJetTest(t, nil, nil, "DefaultFuncs_safeHtml2", `<h1>{{safeHtml: "<h1>Hello Buddy!</h1>"}}</h1>`, `<h1>&lt;h1&gt;Hello Buddy!&lt;/h1&gt;</h1>`)
@@ -689,6 +1354,41 @@ func TestEvalAutoescape(t *testing.T) {
 	RunJetTestWithSet(t, set, nil, nil, "Autoescapee_Test2", "<h1><h1>Hello Buddy!</h1></h1>")
 }
 
+func TestFormatters(t *testing.T) {
+	loader := NewInMemLoader()
+	set := NewSet(loader, WithFloatFormat('f', 2), WithTimeLayout("2006-01-02"))
+	set.RegisterFormatter(reflect.TypeOf((*error)(nil)).Elem(), func(w io.Writer, v reflect.Value) error {
+		_, err := fmt.Fprintf(w, "<error: %v>", v.Interface())
+		return err
+	})
+	set.RegisterFormatter(reflect.TypeOf((*User)(nil)), func(w io.Writer, v reflect.Value) error {
+		if v.IsNil() {
+			_, err := io.WriteString(w, "nobody")
+			return err
+		}
+		_, err := io.WriteString(w, v.Interface().(*User).Name)
+		return err
+	})
+	set.RegisterFormatter(reflect.TypeOf((*strconv.NumError)(nil)), func(w io.Writer, v reflect.Value) error {
+		_, err := io.WriteString(w, "not a number")
+		return err
+	})
+
+	day := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	var vars = make(VarMap)
+	vars.Set("price", 12.5)
+	vars.Set("ratio", float32(1)/3)
+	vars.Set("day", day)
+	vars.Set("dayPtr", &day)
+	vars.Set("nilPtr", (*User)(nil))
+	vars.Set("err", errors.New("failed"))
+	vars.Set("numErr", &strconv.NumError{Func: "ParseInt", Num: "x", Err: strconv.ErrSyntax})
+	vars.Set("user", users[0])
+
+	loader.Set("formatters", `{{ price }} {{ ratio }} {{ 1.0 / 8 }} {{ day }} {{ dayPtr }} {{ nilPtr }} {{ user }} {{ err }} {{ numErr }} {{ price | raw }} {{ len("four") }}`)
+	RunJetTestWithSet(t, set, vars, nil, "formatters", "12.50 0.33 0.12 2021-03-04 2021-03-04 nobody Mario Santos &lt;error: failed&gt; not a number 12.50 4")
+}
+
 func TestFileResolve(t *testing.T) {
 	set := NewSet(NewOSFileSystemLoader("./testData/resolve"))
 	RunJetTestWithSet(t, set, nil, nil, "simple", "simple")
@@ -750,7 +1450,141 @@ func TestBuiltinCollectionFuncs(t *testing.T) {
 	RunJetTest(t, nil, nil, "array_builtin", `{{ m := array( "foo", "bar", "asd", 123)}}{{m}}`, "[foo bar asd 123]")
 }
 
+func TestEvalLiterals(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("user", &User{Name: "José"})
+	vars.Set("count", 3)
+
+	RunJetTest(t, vars, nil, "literal_Map", `{{ m := {"foo": "bar", "asd": 123} }}{{ m }} {{ m.foo }} {{ m["asd"] }}`, "map[asd:123 foo:bar]\x20bar 123")
+	RunJetTest(t, vars, nil, "literal_MapExpressions", `{{ k := "name" }}{{ {k: user.Name, "n": count + 1, "nil": nil} }}`, "map[n:4 name:José nil:<nil>]")
+	RunJetTest(t, vars, nil, "literal_MapNested", `{{ m := {"a": {"b": [1, {"c": 2}]}} }}{{ m.a.b[1].c }}`, "2")
+	RunJetTest(t, vars, nil, "literal_MapEmpty", `{{ len({}) }} {{ {}  }}`, "0 map[]")
+	RunJetTest(t, vars, nil, "literal_Slice", `{{ s := [1, "two", count] }}{{ s }} {{ s[1] }} {{ len(s) }}`, "[1 two 3] two 3")
+	RunJetTest(t, vars, nil, "literal_SliceEmpty", `{{ [] }} {{ len([]) }}`, "[] 0")
+	RunJetTest(t, vars, nil, "literal_SliceTrailingComma", `{{ [1, 2,] }}`, "[1 2]")
+	RunJetTest(t, vars, nil, "literal_Range", `{{ range _, v := [1, 2, 3] }}{{ v }}{{ end }}`, "123")
+	RunJetTest(t, vars, nil, "literal_In", `{{ 2 in [1, 2] }} {{ "a" in {"a": 1} }}`, "true true")
+	RunJetTest(t, vars, nil, "literal_Yield", `{{ block card(opts={"title": "d", "tags": ["t"]}) }}{{ opts.title }}:{{ opts.tags[0] }} {{ end }}{{ yield card(opts={"title": "x", "tags": ["a"]}) }}`, "d:t x:a ")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"mapKey": {`{{ {1: "a"} }}`, "map literal key 1 is float64, not a string"},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
+func TestEvalInterpolatedStrings(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("user", &User{Name: "José"})
+	vars.Set("count", 3)
+	vars.Set("tag", "<b>")
+
+	RunJetTest(t, vars, nil, "interpolation_Simple", "{{ `Hello ${user.Name}, you have ${count} items` }}", "Hello José, you have 3 items")
+	RunJetTest(t, vars, nil, "interpolation_Only", "{{ s := `${count}` }}{{ s + 1 }}", "31")
+	RunJetTest(t, vars, nil, "interpolation_Expressions", "{{ `${count + 1}/${ count > 2 ? \"many\" : \"few\" }/${ {\"k\": user.Name}.k }` }}", "4/many/José")
+	RunJetTest(t, vars, nil, "interpolation_Nested", "{{ `a${ `b${count}` }c` }}", "ab3c")
+	RunJetTest(t, vars, nil, "interpolation_Braces", "{{ `{${count}}` }}", "{3}")
+	RunJetTest(t, vars, nil, "interpolation_Escaped", "{{ `$${count} is ${count}` }}", "${count} is 3")
+	RunJetTest(t, vars, nil, "interpolation_EscapedOnly", "{{ `$${count}` }}", "${count}")
+	RunJetTest(t, vars, nil, "interpolation_Escaping", "{{ `<i>${tag}</i>` | html }}", "&lt;i&gt;&lt;b&gt;&lt;/i&gt;")
+	RunJetTest(t, vars, nil, "interpolation_Argument", "{{ upper(`${user.Name}!`) }}", "JOSÉ!")
+	RunJetTest(t, vars, nil, "interpolation_Multiline", "{{ `a\n${count}\nb` }}", "a\n3\nb")
+	RunJetTest(t, vars, nil, "interpolation_Plain", "{{ `$ {count} $count {}` }}", "$ {count} $count {}")
+	RunJetTest(t, vars, nil, "interpolation_NestedQuotes", "{{ `a${ `}` + \"}\" }b` }}", "a}}b")
+}
+
+func TestEvalNumericRangesAndModifiers(t *testing.T) {
+	vars := VarMap{}
+	vars.Set("s", []string{"a", "b", "c", "d", "e"})
+	vars.Set("from", 2)
+	vars.Set("to", 4.0)
+	vars.Set("ci", &customTestRanger{providesIndex: true, data: []string{"x", "y", "z"}})
+	vars.Set("cu", &customTestRanger{data: []string{"x", "y", "z"}})
+	vars.Set("users", []*User{{Name: "Ana"}, {Name: "Bob"}, {Name: "Cid"}})
+	vars.Set("minInt", int64(math.MinInt64))
+	vars.Set("maxInt", int64(math.MaxInt64))
+
+	RunJetTest(t, vars, nil, "numericRange", `{{ range i := 1..5 }}{{ i }}{{ end }}`, "12345")
+	RunJetTest(t, vars, nil, "numericRange_Descending", `{{ range i := 5..1 }}{{ i }}{{ end }}`, "54321")
+	RunJetTest(t, vars, nil, "numericRange_Single", `{{ range i := 3..3 }}{{ i }}{{ end }}`, "3")
+	RunJetTest(t, vars, nil, "numericRange_Step", `{{ range i := 1..10 step 3 }}{{ i }} {{ end }}|{{ range i := 10..1 step 4 }}{{ i }} {{ end }}`, "1 4 7 10 |10 6 2 ")
+	RunJetTest(t, vars, nil, "numericRange_Expressions", `{{ range i := from..to * 2 step from }}{{ i }}{{ end }}`, "2468")
+	RunJetTest(t, vars, nil, "numericRange_Negative", `{{ range i := -2..2 }}{{ i }} {{ end }}`, "-2 -1 0 1 2 ")
+	RunJetTest(t, vars, nil, "numericRange_Wide", `{{ range i := minInt..maxInt step maxInt }}{{ i }} {{ end }}|{{ range i := maxInt..minInt step maxInt }}{{ i }} {{ end }}`, "-9223372036854775808 -1 9223372036854775806 |9223372036854775807 0 -9223372036854775807 ")
+	RunJetTest(t, vars, nil, "numericRange_Context", `{{ range 1..3 }}{{ . }}{{ end }}`, "123")
+	RunJetTest(t, vars, nil, "numericRange_Loop", `{{ range i := 1..10 step 2 }}{{ loop.index }}:{{ i }}{{ loop.last ? "/" + loop.length : "," }}{{ end }}`, "0:1,1:3,2:5,3:7,4:9/5")
+
+	RunJetTest(t, vars, nil, "modifier_Reverse", `{{ range i, v := s reverse }}{{ i }}{{ v }}{{ end }}|{{ range i := 1..10 step 4 reverse }}{{ i }}{{ end }}`, "4e3d2c1b0a|951")
+	RunJetTest(t, vars, nil, "modifier_ReverseCustom", `{{ range i, v := ci reverse }}{{ i }}{{ v }}{{ end }}|{{ range v := cu reverse }}{{ v }}{{ end }}`, "2z1y0x|zyx")
+	RunJetTest(t, vars, nil, "modifier_Where", `{{ range _, v := [1, 2, 3, 4, 5, 6] where v % 2 == 0 }}{{ v }}{{ end }}|{{ range users where .Name != "Bob" }}{{ .Name }}{{ end }}`, "246|AnaCid")
+	RunJetTest(t, vars, nil, "modifier_WhereLast", `{{ range n := 1..10 where n % 4 == 0 }}{{ loop.last }}{{ n }} {{ end }}`, "false4 true8 ")
+	RunJetTest(t, vars, nil, "modifier_WhereLoop", `{{ range n := 1..10 where n % 4 == 0 }}{{ n }}{{ loop.last ? "." : "," }}{{ loop.length }} {{ end }}`, "4,-1 8.-1 ")
+	RunJetTest(t, vars, nil, "modifier_WhereElse", `{{ range _, v := s where v == "z" }}{{ v }}{{ else }}none{{ end }}`, "none")
+	RunJetTest(t, vars, nil, "modifier_OffsetLimit", `{{ range _, v := s offset 1 limit 3 }}{{ v }}{{ end }}|{{ range _, v := s limit 3 offset 1 }}{{ v }}{{ end }}|{{ range _, v := s limit 0 }}{{ v }}{{ else }}none{{ end }}`, "bcd|bcd|none")
+	RunJetTest(t, vars, nil, "modifier_OffsetPastEnd", `{{ range _, v := s offset 10 }}{{ v }}{{ else }}none{{ end }}`, "none")
+	RunJetTest(t, vars, nil, "modifier_OffsetLimitLoop", `{{ range _, v := s offset 3 limit 5 }}{{ loop.index1 }}/{{ loop.length }}:{{ v }} {{ end }}`, "1/2:d 2/2:e ")
+	RunJetTest(t, vars, nil, "modifier_Batch", `{{ range i, row := s batch 2 }}{{ i }}:{{ row }}{{ loop.length }} {{ end }}|{{ range row := 1..7 batch 3 }}{{ row }}{{ end }}`, "0:[a b]3 1:[c d]3 2:[e]3 |[1 2 3][4 5 6][7]")
+	RunJetTest(t, vars, nil, "modifier_BatchCells", `{{ range _, row := users batch 2 }}<tr>{{ range _, user := row }}<td>{{ user.Name }}</td>{{ end }}</tr>{{ end }}`, "<tr><td>Ana</td><td>Bob</td></tr><tr><td>Cid</td></tr>")
+	RunJetTest(t, vars, nil, "modifier_All", `{{ range row := 1..20 batch 2 limit 4 offset 1 reverse where row % 3 == 0 }}{{ row }}{{ end }}`, "[15 12][9 6]")
+	RunJetTest(t, vars, nil, "modifier_Break", `{{ range i := 1..100 where i > 3 }}{{ if i > 6 }}{{ break }}{{ end }}{{ i }}{{ end }}`, "456")
+
+	for name, test := range map[string]struct{ content, err string }{
+		"numericRangeStep":   {`{{ range i := 1..3 step 0 }}{{ end }}`, "step of numeric range must be positive, but is 0"},
+		"numericRangeEnd":    {`{{ range i := 1.."x" }}{{ end }}`, "end of range is string, not a number"},
+		"numericRangeStart":  {`{{ range i := nil..3 }}{{ end }}`, "start of range is <invalid>, not a number"},
+		"numericRangeFloat":  {`{{ range i := 0..2.5 }}{{ end }}`, "end of range must be an integer, but is 2.5"},
+		"numericRangeHuge":   {`{{ range i := 0..1e19 }}{{ end }}`, "end of range: 1e+19 overflows int64"},
+		"numericRangeCount":  {`{{ range i := minInt..maxInt }}{{ end }}`, "numeric range -9223372036854775808..9223372036854775807 step 1 produces too many integers"},
+		"modifierLimit":      {`{{ range s limit -1 }}{{ end }}`, "limit of range can't be negative, but is -1"},
+		"modifierOffset":     {`{{ range s offset -2 }}{{ end }}`, "offset of range can't be negative, but is -2"},
+		"modifierBatch":      {`{{ range s batch 0 }}{{ end }}`, "batch size of range must be positive, but is 0"},
+		"modifierBatchValue": {`{{ range s batch "2" }}{{ end }}`, "batch size of range is string, not a number"},
+	} {
+		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
+		tt, err := set.parse(name, test.content, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.Execute(io.Discard, vars, nil)
+		if err == nil {
+			t.Errorf("expected %s to fail with a runtime error, but got nil", name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected runtime error of %s to contain %q, but got %q", name, test.err, err.Error())
+		}
+	}
+}
+
 // customTestRanger satisfies the Ranger interface for custom tests.
+// bufferTestRanger reuses the same string for all the values it produces.
+type bufferTestRanger struct {
+	data []string
+	i    int
+	buf  string
+}
+
+func (r *bufferTestRanger) ProvidesIndex() bool { return false }
+
+func (r *bufferTestRanger) Range() (_, v reflect.Value, done bool) {
+	if r.i >= len(r.data) {
+		r.i = 0
+		done = true
+		return
+	}
+	r.buf = r.data[r.i]
+	r.i++
+	return reflect.Value{}, reflect.ValueOf(&r.buf).Elem(), false
+}
+
 type customTestRanger struct {
 	providesIndex bool
 	data          []string
@@ -853,13 +1687,16 @@ func TestDivisionByZero(t *testing.T) {
 		"int division by zero":                   `{{ 5 / 0 }}`,
 		"int division by zero (float promotion)": `{{ 5 / 0.0 }}`,
 		"float division by zero":                 `{{ 5.0 / 0.0 }}`,
+		"int modulo by zero":                     `{{ 5 % 0 }}`,
+		"uint modulo by zero":                    `{{ u % 0 }}`,
+		"float modulo by zero":                   `{{ 5.5 % 0 }}`,
 	} {
 		var set = NewSet(NewInMemLoader(), WithSafeWriter(nil))
 		tt, err := set.parse(name, template, false)
 		if err != nil {
 			t.Fatal(err)
 		}
-		err = tt.Execute(io.Discard, nil, nil)
+		err = tt.Execute(io.Discard, VarMap{}.Set("u", uint(5)), nil)
 		if err == nil {
 			t.Fatal("expected division by zero to fail with a runtime error, but got nil")
 		}
@@ -869,6 +1706,407 @@ func TestDivisionByZero(t *testing.T) {
 	}
 }
 
+func TestEvalArithmeticRules(t *testing.T) {
+	vars := VarMap{}.
+		Set("i", 7).
+		Set("i8", int8(-3)).
+		Set("u", uint(7)).
+		Set("u8", uint8(200)).
+		Set("f32", float32(0.5)).
+		Set("f", 2.5).
+		Set("maxInt", int64(math.MaxInt64)).
+		Set("minInt", int64(math.MinInt64)).
+		Set("maxUint", uint64(math.MaxUint64)).
+		Set("bigUint", uint64(math.MaxInt64)+1).
+		Set("id", int64(9007199254740993)). // not representable as a float64
+		Set("id2", int64(9007199254740992)).
+		Set("one", 1).
+		Set("two", 2).
+		Set("three", 3).
+		Set("four", 4).
+		Set("ten", 10).
+		Set("printf", fmt.Sprintf)
+
+	for name, test := range map[string]struct {
+		template, expected string
+	}{
+		// literals
+		"int literal":         {`{{ printf("%[1]T %[1]v", 42) }}`, "float64 42"},
+		"float literal":       {`{{ printf("%[1]T %[1]v", 4.2) }}`, "float64 4.2"},
+		"exponent literal":    {`{{ printf("%[1]T %[1]v", 1e3) }}`, "float64 1000"},
+		"hex literal":         {`{{ printf("%[1]T %[1]v", 0x1E) }}`, "float64 30"},
+		"char literal":        {`{{ printf("%[1]T %[1]v", 'a') }}`, "float64 97"},
+		"huge int literal":    {`{{ printf("%[1]T %[1]v", 18446744073709551615) }}`, "float64 1.8446744073709552e+19"},
+		"negative literal":    {`{{ printf("%[1]T %[1]v", -5) }}`, "float64 -5"},
+		"literal division":    {`{{ 7 / 2 }} {{ i / 2 }} {{ 7 % 2 }}`, "3.5 3.5 1"},
+		"negative of uint":    {`{{ printf("%[1]T %[1]v", -u) }}`, "int64 -7"},
+		"negative of min int": {`{{ printf("%[1]T %[1]v", -bigUint) }}`, "int64 -9223372036854775808"},
+		"plus keeps type":     {`{{ printf("%[1]T %[1]v", +u8) }}`, "uint8 200"},
+
+		// signed op signed
+		"int add":          {`{{ printf("%[1]T %[1]v", i + three) }}`, "int64 10"},
+		"int sub":          {`{{ printf("%[1]T %[1]v", i8 - three) }}`, "int64 -6"},
+		"int mul":          {`{{ printf("%[1]T %[1]v", i * i8) }}`, "int64 -21"},
+		"int div":          {`{{ printf("%[1]T %[1]v", i / two) }}`, "int64 3"},
+		"int div negative": {`{{ printf("%[1]T %[1]v", -i / two) }}`, "int64 -3"},
+		"int mod":          {`{{ printf("%[1]T %[1]v", i % four) }}`, "int64 3"},
+		"int mod negative": {`{{ printf("%[1]T %[1]v", -i % four) }}`, "int64 -3"},
+		"int precision":    {`{{ printf("%[1]T %[1]v", id + one) }}`, "int64 9007199254740994"},
+		"int max":          {`{{ printf("%[1]T %[1]v", maxInt - one + one) }}`, "int64 9223372036854775807"},
+		"int min":          {`{{ printf("%[1]T %[1]v", minInt + one - one) }}`, "int64 -9223372036854775808"},
+
+		// unsigned op unsigned
+		"uint add": {`{{ printf("%[1]T %[1]v", u + u8) }}`, "uint64 207"},
+		"uint sub": {`{{ printf("%[1]T %[1]v", u8 - u) }}`, "uint64 193"},
+		"uint mul": {`{{ printf("%[1]T %[1]v", u * u8) }}`, "uint64 1400"},
+		"uint div": {`{{ printf("%[1]T %[1]v", u8 / u) }}`, "uint64 28"},
+		"uint mod": {`{{ printf("%[1]T %[1]v", u8 % u) }}`, "uint64 4"},
+		"uint max": {`{{ printf("%[1]T %[1]v", maxUint - u + u) }}`, "uint64 18446744073709551615"},
+
+		// signed op unsigned
+		"mixed add":          {`{{ printf("%[1]T %[1]v", u + one) }}`, "int64 8"},
+		"mixed sub negative": {`{{ printf("%[1]T %[1]v", u - ten) }}`, "int64 -3"},
+		"mixed mul":          {`{{ printf("%[1]T %[1]v", i8 * u8) }}`, "int64 -600"},
+		"mixed div":          {`{{ printf("%[1]T %[1]v", u8 / i8) }}`, "int64 -66"},
+		"mixed mod":          {`{{ printf("%[1]T %[1]v", i % u) }}`, "int64 0"},
+
+		// float promotion
+		"int plus float":     {`{{ printf("%[1]T %[1]v", i + 0.5) }}`, "float64 7.5"},
+		"float plus int":     {`{{ printf("%[1]T %[1]v", f + i) }}`, "float64 9.5"},
+		"uint times float":   {`{{ printf("%[1]T %[1]v", u * f) }}`, "float64 17.5"},
+		"float32 operand":    {`{{ printf("%[1]T %[1]v", f32 * 3) }}`, "float64 1.5"},
+		"int div float":      {`{{ printf("%[1]T %[1]v", 7 / 2.0) }}`, "float64 3.5"},
+		"float mod":          {`{{ printf("%[1]T %[1]v", 7.5 % 2) }}`, "int64 1"},
+		"float mod negative": {`{{ printf("%[1]T %[1]v", -7.5 % 2) }}`, "int64 -1"},
+
+		// strings as right operands
+		"int plus int string":   {`{{ printf("%[1]T %[1]v", i + "3") }}`, "int64 10"},
+		"int plus float string": {`{{ printf("%[1]T %[1]v", i + "0.5") }}`, "float64 7.5"},
+		"uint times string":     {`{{ printf("%[1]T %[1]v", u * "2") }}`, "int64 14"},
+		"string concatenation":  {`{{ printf("%[1]T %[1]v", "7" + 3) }}`, "string 73"},
+
+		// comparisons
+		"int compare":             {`{{ i > 6 }} {{ i >= 7 }} {{ i < 7 }} {{ i <= 6 }}`, "true true false false"},
+		"negative int below uint": {`{{ i8 < u }} {{ i8 > u }} {{ u > i8 }}`, "true false true"},
+		"big uint above int":      {`{{ maxUint > maxInt }} {{ maxInt < bigUint }} {{ maxInt >= maxUint }}`, "true true false"},
+		"precise int compare":     {`{{ id > id2 }}`, "true"},
+		"int float compare":       {`{{ i > 6.5 }} {{ 2 < f }} {{ f32 <= 0.5 }}`, "true true true"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := tt.Execute(&buf, vars, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+
+	for name, test := range map[string]struct {
+		template, err string
+	}{
+		"int add overflow":          {`{{ maxInt + one }}`, "integer overflow: 9223372036854775807 + 1 overflows int64"},
+		"int sub overflow":          {`{{ minInt - one }}`, "integer overflow: -9223372036854775808 - 1 overflows int64"},
+		"int mul overflow":          {`{{ maxInt * two }}`, "integer overflow: 9223372036854775807 * 2 overflows int64"},
+		"int mul min overflow":      {`{{ minInt * -one }}`, "integer overflow: -9223372036854775808 * -1 overflows int64"},
+		"int div overflow":          {`{{ minInt / -one }}`, "integer overflow: -9223372036854775808 / -1 overflows int64"},
+		"int negation overflow":     {`{{ -minInt }}`, "integer overflow: -(-9223372036854775808) overflows int64"},
+		"uint add overflow":         {`{{ maxUint + u }}`, "integer overflow: 18446744073709551615 + 7 overflows uint64"},
+		"uint sub overflow":         {`{{ u - u8 }}`, "integer overflow: 7 - 200 overflows uint64"},
+		"uint mul overflow":         {`{{ maxUint * u }}`, "integer overflow: 18446744073709551615 * 7 overflows uint64"},
+		"uint negation overflow":    {`{{ -maxUint }}`, "integer overflow: -18446744073709551615 overflows int64"},
+		"mixed operand overflow":    {`{{ bigUint + one }}`, "integer overflow: 9223372036854775808 overflows int64 in arithmetic with a signed integer"},
+		"mixed result overflow":     {`{{ u + maxInt }}`, "integer overflow: 7 + 9223372036854775807 overflows int64"},
+		"int division by zero":      {`{{ i / 0 }}`, "division by zero"},
+		"int modulo by zero":        {`{{ i % (u - u) }}`, "division by zero"},
+		"float division by zero":    {`{{ f / 0 }}`, "division by zero"},
+		"right side not numeric":    {`{{ i * true }}`, "multiplicative expression: right side true (bool) is not a numeric value"},
+		"right side not a number":   {`{{ i - "x" }}`, `additive expression: "x" is not a number`},
+		"left side not numeric":     {`{{ true * i }}`, "a non numeric value in multiplicative expression"},
+		"compare with non numeric":  {`{{ i > nil }}`, "numeric comparative expression: right side nil (<invalid>) is not a numeric value"},
+		"unary minus of non number": {`{{ -"x" }}`, `additive expression: right side "x" (string) is not a numeric value (no left side)`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, "\n"+test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.Execute(io.Discard, vars, nil)
+			if err == nil {
+				t.Fatalf("expected error %q, got nil", test.err)
+			}
+			location := fmt.Sprintf("(%q:2)", name)
+			if !strings.Contains(err.Error(), location) || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q at %s, got %q", test.err, location, err.Error())
+			}
+		})
+	}
+}
+
+// decimal is a minimal decimal type, implementing Rational like most decimal packages.
+type decimal struct {
+	unscaled int64
+	scale    int
+}
+
+func (d decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(d.unscaled), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil))
+}
+
+func (d decimal) String() string {
+	return d.Rat().FloatString(d.scale)
+}
+
+func TestEvalBigNumbers(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	vars := VarMap{}.
+		Set("huge", huge).
+		Set("two", big.NewInt(2)).
+		Set("seven", big.NewInt(7)).
+		Set("half", big.NewRat(1, 2)).
+		Set("third", big.NewRat(1, 3)).
+		Set("pi", big.NewFloat(3.25)).
+		Set("price", decimal{unscaled: 1999, scale: 2}).
+		Set("invoice", struct{ Total big.Int }{Total: *big.NewInt(42)}).
+		Set("printf", fmt.Sprintf)
+
+	for name, test := range map[string]struct {
+		template, expected string
+	}{
+		"big int add":            {`{{ huge + 1 }}`, "123456789012345678901234567891"},
+		"big int mul":            {`{{ huge * two }}`, "246913578024691357802469135780"},
+		"big int div":            {`{{ huge / 10 }}`, "12345678901234567890123456789"},
+		"big int mod":            {`{{ huge % 11 }}`, "7"},
+		"big int float div":      {`{{ printf("%T %[1]v", seven / 2) }} {{ printf("%T %[1]v", 14 / seven) }}`, "*big.Rat 7/2 *big.Int 2"},
+		"big int int div":        {`{{ seven / two }} {{ seven / u }} {{ 15 % seven }}`, "3 1 1"},
+		"big int uint":           {`{{ two * u }}`, "14"},
+		"big int value":          {`{{ invoice.Total }} {{ invoice.Total + 1 }}`, "42 43"},
+		"big int negation":       {`{{ -two }}`, "-2"},
+		"big int type":           {`{{ printf("%T", two + 1) }}`, "*big.Int"},
+		"big rat add":            {`{{ half + third }}`, "5/6"},
+		"big rat float":          {`{{ third * 0.3 }}`, "1/10"},
+		"big int float":          {`{{ printf("%T %[1]v", two * 0.1) }}`, "*big.Rat 1/5"},
+		"big rat div":            {`{{ half / third }}`, "3/2"},
+		"big float add":          {`{{ pi + 1 }}`, "4.25"},
+		"big float rat":          {`{{ printf("%T", pi * half) }}`, "*big.Float"},
+		"decimal add":            {`{{ price + 0.01 }}`, "20/1"},
+		"decimal mul":            {`{{ price * 3 }}`, "5997/100"},
+		"decimal printed":        {`{{ price }}`, "19.99"},
+		"operands not modified":  {`{{ two + 1 }} {{ two * 3 }} {{ -half }} {{ two }} {{ half }}`, "3 6 -1/2 2 1/2"},
+		"big int compare":        {`{{ huge > 1 }} {{ two < 1 }} {{ two >= 2 }} {{ 3 > two }}`, "true false true true"},
+		"big rat compare":        {`{{ third < half }} {{ half <= 0.5 }} {{ third > 0.33 }}`, "true true true"},
+		"decimal compare":        {`{{ price > 19.98 }} {{ price < 20 }} {{ price >= half }}`, "true true true"},
+		"big float compare":      {`{{ pi > 3 }} {{ pi < two }}`, "true false"},
+		"big equality":           {`{{ two == 2 }} {{ two == 2.0 }} {{ 2 == two }} {{ half == 0.5 }} {{ half != third }}`, "true true true true true"},
+		"big equality of values": {`{{ two * 2 == 4 }} {{ two == "2" }} {{ price == 19.99 }}`, "true false true"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := tt.Execute(&buf, vars.Set("u", uint(7)), nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+
+	for name, test := range map[string]struct {
+		template, err string
+	}{
+		"big int division by zero":   {`{{ huge / 0 }}`, "division by zero"},
+		"big rat division by zero":   {`{{ half / (two - 2) }}`, "division by zero"},
+		"big float division by zero": {`{{ pi / 0 }}`, "division by zero"},
+		"big rat modulo":             {`{{ half % 2 }}`, "operator % is not defined for *big.Rat"},
+		"big float modulo":           {`{{ pi % 2 }}`, "operator % is not defined for *big.Float"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.Execute(io.Discard, vars, nil)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+type semver struct {
+	Major, Minor, Patch int
+}
+
+func (v semver) Compare(other semver) int {
+	switch {
+	case v.Major != other.Major:
+		return v.Major - other.Major
+	case v.Minor != other.Minor:
+		return v.Minor - other.Minor
+	}
+	return v.Patch - other.Patch
+}
+
+type money struct {
+	Cents    int64
+	Currency string
+	note     string
+}
+
+func (m *money) Equal(other money) bool {
+	return m.Cents == other.Cents && m.Currency == other.Currency
+}
+
+func TestEvalComparisons(t *testing.T) {
+	t1 := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	s := []int{1, 2}
+	vars := VarMap{}.
+		Set("t1", t1).
+		Set("t2", t1.Add(time.Hour)).
+		Set("t3", t1.In(time.FixedZone("UTC+2", 2*60*60))).
+		Set("v1", semver{1, 2, 3}).
+		Set("v1b", semver{1, 2, 3}).
+		Set("v2", &semver{1, 10, 0}).
+		Set("m1", money{Cents: 100, Currency: "EUR", note: "a"}).
+		Set("m2", &money{Cents: 100, Currency: "EUR", note: "b"}).
+		Set("m3", money{Cents: 100, Currency: "USD"}).
+		Set("u", uint(1)).
+		Set("a1", [2]int{1, 2}).
+		Set("a2", [2]int{1, 2}).
+		Set("a3", [2]int{1, 3}).
+		Set("s1", s).
+		Set("s2", []int{1, 2}).
+		Set("s3", s).
+		Set("map1", map[string]int{"a": 1}).
+		Set("map2", map[string]interface{}{"a": 1.0}).
+		Set("p1", &struct{ N int }{1}).
+		Set("p2", &struct{ N int }{1}).
+		Set("d1", 90*time.Second)
+
+	for name, test := range map[string]struct {
+		template, expected string
+	}{
+		"time ordering":           {`{{ t1 < t2 }} {{ t1 >= t2 }} {{ t2 > t1 }} {{ t1 <= t3 }}`, "true false true true"},
+		"time equality":           {`{{ t1 == t3 }} {{ t1 != t2 }}`, "true true"},
+		"duration":                {`{{ d1 > 60000000000 }} {{ d1 == 90000000000 }}`, "true true"},
+		"compare method":          {`{{ v1 < v2 }} {{ v2 >= v1 }} {{ v1 > v2 }} {{ v1 <= v1b }}`, "true true false true"},
+		"compare method equality": {`{{ v1 == v1b }} {{ v1 == v2 }} {{ v1 != v2 }} {{ v2 == v2 }}`, "true false true true"},
+		"equal method":            {`{{ m1 == m2 }} {{ m2 == m1 }} {{ m1 == m3 }} {{ m1 != m3 }}`, "true true false true"},
+		"numbers":                 {`{{ 1 == 1.5 }} {{ 1 == 1.0 }} {{ u == -1 }} {{ u == 1 }}`, "false true false true"},
+		"arrays":                  {`{{ a1 == a2 }} {{ a1 == a3 }}`, "true false"},
+		"slices":                  {`{{ s1 == s3 }} {{ s1 == s2 }} {{ deepEqual(s1, s2) }}`, "true false true"},
+		"maps":                    {`{{ map1 == map1 }} {{ map1 == map2 }} {{ deepEqual(map1, map2) }}`, "true false true"},
+		"pointers":                {`{{ p1 == p1 }} {{ p1 == p2 }} {{ deepEqual(p1, p2) }} {{ deepEqual(p1, v1) }}`, "true false true false"},
+		"deep equality":           {`{{ deepEqual(slice(1, slice("a")), slice(1.0, slice("a"))) }} {{ deepEqual(slice(1), slice(2)) }}`, "true false"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := tt.Execute(&buf, vars, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+
+	set := NewSet(NewInMemLoader())
+	tt, err := set.parse("not comparable", `{{ v1 < m1 }}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tt.Execute(io.Discard, vars, nil)
+	if err == nil || !strings.Contains(err.Error(), "a non numeric value in numeric comparative expression") {
+		t.Errorf("expected comparing unrelated types to fail, got %v", err)
+	}
+}
+
+type vector struct {
+	X, Y int64
+}
+
+func (v vector) JetAdd(other reflect.Value) (reflect.Value, error) {
+	o, ok := other.Interface().(vector)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("can't add %s to a vector", other.Type())
+	}
+	return reflect.ValueOf(vector{v.X + o.X, v.Y + o.Y}), nil
+}
+
+func (v vector) JetMul(other reflect.Value) (reflect.Value, error) {
+	if other.Kind() != reflect.Float64 {
+		return reflect.Value{}, fmt.Errorf("can't multiply a vector by %s", other.Type())
+	}
+	f := int64(other.Float())
+	return reflect.ValueOf(vector{v.X * f, v.Y * f}), nil
+}
+
+func (v vector) String() string {
+	return fmt.Sprintf("(%d, %d)", v.X, v.Y)
+}
+
+func (m *money) JetSub(other reflect.Value) (reflect.Value, error) {
+	o := other.Interface().(money)
+	if o.Currency != m.Currency {
+		return reflect.Value{}, fmt.Errorf("can't subtract %s from %s", o.Currency, m.Currency)
+	}
+	return reflect.ValueOf(money{Cents: m.Cents - o.Cents, Currency: m.Currency}), nil
+}
+
+func TestEvalOperatorOverloading(t *testing.T) {
+	vars := VarMap{}.
+		Set("v", vector{1, 2}).
+		Set("w", vector{10, 20}).
+		Set("eur", &money{Cents: 500, Currency: "EUR"}).
+		Set("order", &struct{ Total, Discount money }{money{Cents: 500, Currency: "EUR"}, money{Cents: 150, Currency: "EUR"}}).
+		Set("usd", money{Cents: 100, Currency: "USD"})
+
+	RunJetTest(t, vars, nil, "operator_add", `{{ v + w }}`, "(11, 22)")
+	RunJetTest(t, vars, nil, "operator_mul", `{{ v * 3 }}`, "(3, 6)")
+	RunJetTest(t, vars, nil, "operator_chain", `{{ v + w * 2 + v }}`, "(22, 44)")
+	RunJetTest(t, vars, nil, "operator_pointer_receiver", `{{ (order.Total - order.Discount).Cents }}`, "350")
+	RunJetTest(t, vars, nil, "operator_string_right_side", `{{ "v=" + v }}`, "v=(1, 2)")
+
+	for name, test := range map[string]struct {
+		template, err string
+	}{
+		"operator error":       {`{{ v + 1 }}`, "can't add float64 to a vector"},
+		"operator error 2":     {`{{ eur - usd }}`, "can't subtract USD from EUR"},
+		"operator not defined": {`{{ v - w }}`, "additive expression: left side v (jet.vector) is not a numeric value"},
+		"right operand only":   {`{{ 3 * v }}`, "multiplicative expression: right side v (jet.vector) is not a numeric value"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			set := NewSet(NewInMemLoader())
+			tt, err := set.parse(name, test.template, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.Execute(io.Discard, vars, nil)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
 func TestRecursiveInclude(t *testing.T) {
 	l := NewInMemLoader()
 	l.Set("recursive_incl_1", `{{ include "./recursive_incl_2" }}`)
@@ -884,6 +2122,101 @@ func TestRecursiveInclude(t *testing.T) {
 	}
 }
 
+func TestAsyncInclude(t *testing.T) {
+	l := NewInMemLoader()
+	l.Set("async_part", `[{{ arrive() }} {{ . }}{{ x := "local" }}]`)
+	l.Set("async", `a{{ include "async_part" 1 async }}b{{ include "async_part" 2 async }}c{{ include "./async_part" 3 async }}d{{ isset(x) ? x : "" }}`)
+	l.Set("async_broken", `a{{ include "async_part" 1 async }}{{ include "async_fails" async }}b`)
+	l.Set("async_fails", `{{ undefined_identifier }}`)
+	l.Set("async_try", `a{{ try }}{{ include "async_fails" async }}b{{ catch }}failed{{ end }}c`)
+	l.Set("async_range", `a{{ range i := 1..3 }}{{ include "async_part" i async }}{{ end }}b`)
+	l.Set("async_loop_part", `{{ loop.index }}{{ loop.last ? "." : "," }}`)
+	l.Set("async_loop", `{{ range 1..3 }}{{ include "async_loop_part" async }}{{ end }}`)
+	l.Set("async_echo", `[{{ . }}]`)
+	l.Set("async_variable", `{{ async := "a" }}{{ async }}{{ include "async_echo" async }}{{ include "async_echo" (async) }}`)
+	l.Set("async_lambda_part", `{{ n := 0 }}{{ range k := 1..500 }}{{ n = f(k) }}{{ end }}{{ n }}`)
+	l.Set("async_lambda", `{{ f := x => x + 1 }}{{ include "async_lambda_part" async }}|{{ range i := 1..500 }}{{ j := i }}{{ end }}{{ parallel(f) }}`)
+	var set = NewSet(l, WithSafeWriter(nil))
+
+	// every include blocks until all three are running at the same time
+	barrier := func() func() string {
+		var wg sync.WaitGroup
+		wg.Add(3)
+		allArrived := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(allArrived)
+		}()
+		return func() string {
+			wg.Done()
+			select {
+			case <-allArrived:
+				return "ok"
+			case <-time.After(5 * time.Second):
+				return "timeout"
+			}
+		}
+	}
+	vars := VarMap{}
+	vars.Set("arrive", barrier())
+	RunJetTestWithSet(t, set, vars, nil, "async", "a[ok 1]b[ok 2]c[ok 3]d")
+
+	// the includes executed in a range body are only waited for at the end of the template
+	vars.Set("arrive", barrier())
+	RunJetTestWithSet(t, set, vars, nil, "async_range", "a[ok 1][ok 2][ok 3]b")
+	RunJetTestWithSet(t, set, nil, nil, "async_loop", "0,1,2.")
+
+	vars.Set("arrive", func() string { return "ok" })
+	tt, err := set.GetTemplate("async_broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tt.Execute(io.Discard, vars, nil)
+	if err == nil {
+		t.Fatal("expected async include to fail with a runtime error, but got nil")
+	}
+	if !strings.Contains(err.Error(), `identifier "undefined_identifier" not available`) {
+		t.Errorf("expected runtime error of the included template, but got %q", err.Error())
+	}
+
+	RunJetTestWithSet(t, set, nil, nil, "async_try", "afailedc")
+
+	// async is a variable unless it ends an include
+	RunJetTestWithSet(t, set, nil, "ctx", "async_variable", "a[ctx][a]")
+
+	// lambdas called by async includes and by other goroutines don't use the runtime of the template creating them
+	vars.Set("parallel", func(fn func(int) int) int {
+		var wg sync.WaitGroup
+		var sum int64
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					atomic.AddInt64(&sum, int64(fn(1)))
+				}
+			}()
+		}
+		wg.Wait()
+		return int(sum)
+	})
+	RunJetTestWithSet(t, set, vars, nil, "async_lambda", "501|800")
+}
+
+func TestEvalCompiledExpressions(t *testing.T) {
+	type named struct{ Name string }
+	type titled struct{ Name, Title string }
+	var data = make(VarMap)
+	data.Set("items", []interface{}{named{"a"}, &titled{"b", "x"}, named{"c"}, map[string]string{"Name": "d"}})
+
+	// field accesses cache the field index per type, so a node seeing different types must still resolve each one
+	RunJetTest(t, data, nil, "compiled_mixed_types", `{{ range items }}{{ .Name }}{{ end }}`, "abcd")
+	RunJetTest(t, data, named{"ctx"}, "compiled_context_field", `{{ .Name }}-{{ .Name + "!" }}`, "ctx-ctx!")
+
+	// constant subexpressions are folded, but must produce the same output as at runtime
+	RunJetTest(t, nil, nil, "compiled_constant_folding", `{{ 1 + 2 * 3 }} {{ "a" + "b" }} {{ !true || 2 > 1 }} {{ true ? "yes" : x }}`, "7 ab true yes")
+}
+
 func BenchmarkSimpleAction(b *testing.B) {
 	t, _ := JetTestingSet.GetTemplate("actionNode_dummy")
 	b.ResetTimer()
@@ -1115,6 +2448,60 @@ func BenchmarkExecPipeline(b *testing.B) {
 
 }
 
+type benchProfile struct {
+	Name string
+}
+
+func (p *benchProfile) DisplayName() string {
+	return p.Name
+}
+
+type benchAccount struct {
+	Profile benchProfile
+}
+
+type benchItem struct {
+	User *benchAccount
+}
+
+func benchItems(n int) []benchItem {
+	items := make([]benchItem, n)
+	for i := range items {
+		items[i].User = &benchAccount{Profile: benchProfile{Name: "Mario"}}
+	}
+	return items
+}
+
+// BenchmarkChainMethod benchmarks executing a template that calls a method at
+// the end of a chain of field accesses.
+func BenchmarkChainMethod(b *testing.B) {
+	t, _ := JetTestingSet.GetTemplate("BenchChainMethod")
+	items := benchItems(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := t.Execute(ww, nil, items)
+		if err != nil {
+			b.Error(err.Error())
+		}
+	}
+}
+
+// BenchmarkChainField benchmarks executing a template that accesses a field
+// at the end of a chain of field accesses.
+func BenchmarkChainField(b *testing.B) {
+	t, _ := JetTestingSet.GetTemplate("BenchChainField")
+	items := benchItems(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := t.Execute(ww, nil, items)
+		if err != nil {
+			b.Error(err.Error())
+		}
+	}
+}
+
 // BenchmarkFieldAccess benchmarks executing a template that accesses fields
 // in the current context.
 //
@@ -59,6 +59,8 @@ func (t *Template) Execute(w io.Writer, variables VarMap, data interface{}) (err
 	st.blocks = t.processedBlocks
 	st.variables = variables
 	st.set = t.set
+	st.globals = t.set.globalsSnapshot()
+	st.formatters = t.set.formatterSnapshot()
 	st.Writer = w
 
 	// resolve extended template
@@ -70,6 +72,6 @@ func (t *Template) Execute(w io.Writer, variables VarMap, data interface{}) (err
 		st.context = reflect.ValueOf(data)
 	}
 
-	st.executeList(t.Root)
+	st.executeListFlushing(t.Root)
 	return
 }
@@ -0,0 +1,163 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/CloudyKit/fastprinter"
+)
+
+// Formatter writes the textual representation of v to w. Formatters are registered for a type or a kind
+// of values using Set.RegisterFormatter and are used when printing the result of an action, instead of
+// github.com/CloudyKit/fastprinter.
+type Formatter func(w io.Writer, v reflect.Value) error
+
+// formatterRegistry holds the formatters registered in a set. It is never modified once in use: registering a
+// formatter replaces the registry of the set with a modified copy.
+type formatterRegistry struct {
+	types      map[reflect.Type]Formatter
+	interfaces []interfaceFormatter // in order of registration
+	kinds      map[reflect.Kind]Formatter
+}
+
+type interfaceFormatter struct {
+	typ       reflect.Type
+	formatter Formatter
+}
+
+// lookup returns the formatter for values of type typ: a formatter registered for typ itself, or else for the
+// first registered interface implemented by typ, or else for the kind of typ.
+func (r *formatterRegistry) lookup(typ reflect.Type) Formatter {
+	if f, ok := r.types[typ]; ok {
+		return f
+	}
+	for _, i := range r.interfaces {
+		if typ.Implements(i.typ) {
+			return i.formatter
+		}
+	}
+	return r.kinds[typ.Kind()]
+}
+
+func (r *formatterRegistry) clone() *formatterRegistry {
+	c := &formatterRegistry{
+		types: make(map[reflect.Type]Formatter),
+		kinds: make(map[reflect.Kind]Formatter),
+	}
+	if r == nil {
+		return c
+	}
+	for typ, f := range r.types {
+		c.types[typ] = f
+	}
+	c.interfaces = append(c.interfaces, r.interfaces...)
+	for kind, f := range r.kinds {
+		c.kinds[kind] = f
+	}
+	return c
+}
+
+// RegisterFormatter registers formatter to print values of the type or kind given by typeOrKind, which must
+// be a reflect.Type or a reflect.Kind. When the type is an interface type, the formatter is used for values
+// implementing the interface. A formatter registered for a type takes precedence over one registered for an
+// interface the type implements, which takes precedence over one registered for its kind. Values
+// implementing Renderer keep rendering themselves.
+// Templates already being executed keep using the formatters registered when they started.
+// It returns the Set it was called on to allow for method chaining.
+func (s *Set) RegisterFormatter(typeOrKind interface{}, formatter Formatter) *Set {
+	s.fmx.Lock()
+	defer s.fmx.Unlock()
+
+	registry := s.formatterSnapshot().clone()
+	switch key := typeOrKind.(type) {
+	case reflect.Type:
+		if key.Kind() != reflect.Interface {
+			registry.types[key] = formatter
+			break
+		}
+		for i := range registry.interfaces {
+			if registry.interfaces[i].typ == key {
+				registry.interfaces[i].formatter = formatter
+				return s
+			}
+		}
+		registry.interfaces = append(registry.interfaces, interfaceFormatter{typ: key, formatter: formatter})
+	case reflect.Kind:
+		registry.kinds[key] = formatter
+	default:
+		panic(fmt.Errorf("jet: RegisterFormatter() needs a reflect.Type or reflect.Kind, got %T", typeOrKind))
+	}
+	s.formatters.Store(registry)
+	return s
+}
+
+// formatterSnapshot returns the formatters registered in the set, or nil if there are none.
+func (s *Set) formatterSnapshot() *formatterRegistry {
+	return s.formatters.Load().(*formatterRegistry)
+}
+
+// WithFloatFormat returns an option function that makes the Set print floating point numbers using
+// strconv.FormatFloat with the given format and precision, e.g. WithFloatFormat('f', 2) for two decimals.
+func WithFloatFormat(format byte, precision int) Option {
+	formatter := func(w io.Writer, v reflect.Value) error {
+		_, err := w.Write(strconv.AppendFloat(nil, v.Float(), format, precision, v.Type().Bits()))
+		return err
+	}
+	return func(s *Set) {
+		s.RegisterFormatter(reflect.Float32, formatter)
+		s.RegisterFormatter(reflect.Float64, formatter)
+	}
+}
+
+// WithTimeLayout returns an option function that makes the Set print time.Time values using layout,
+// as accepted by time.Time.Format.
+func WithTimeLayout(layout string) Option {
+	return func(s *Set) {
+		s.RegisterFormatter(reflect.TypeOf(time.Time{}), func(w io.Writer, v reflect.Value) error {
+			_, err := w.Write(v.Interface().(time.Time).AppendFormat(nil, layout))
+			return err
+		})
+	}
+}
+
+// formatValue writes v to w, using the formatter registered for its type or kind, or fastprinter if there is
+// none. Pointers without a formatter of their own are formatted like the value they point to, and math/big
+// numbers are printed using their String method.
+func (st *Runtime) formatValue(w io.Writer, v reflect.Value) error {
+	if st.formatters != nil {
+		for f := v; f.IsValid(); f = f.Elem() {
+			if formatter := st.formatters.lookup(f.Type()); formatter != nil {
+				return formatter(w, f)
+			}
+			if (f.Kind() != reflect.Ptr && f.Kind() != reflect.Interface) || f.IsNil() {
+				break
+			}
+		}
+	}
+	if v.Kind() == reflect.Struct {
+		switch v.Type() {
+		case bigIntType, bigRatType, bigFloatType:
+			// math/big numbers implement fmt.Stringer on their pointer type
+			v = bigPointer(v)
+		}
+	}
+	_, err := fastprinter.PrintValue(w, v)
+	return err
+}
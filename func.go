@@ -17,7 +17,7 @@ package jet
 import (
 	"fmt"
 	"reflect"
-	"time"
+	"strings"
 )
 
 // Arguments holds the arguments passed to jet.Func.
@@ -83,6 +83,55 @@ func (a *Arguments) Get(argumentIndex int) reflect.Value {
 	return reflect.Value{}
 }
 
+// Named gets an argument passed by name, like limit in fn(items, limit: 10). It returns the zero reflect.Value
+// if the call has no argument with that name.
+func (a *Arguments) Named(name string) reflect.Value {
+	for _, named := range a.args.Named {
+		if named.Name == name {
+			return a.runtime.evalPrimaryExpressionGroup(named.Expression)
+		}
+	}
+	return reflect.Value{}
+}
+
+// NamedArguments returns the names of the arguments passed by name, in the order they were passed in.
+func (a *Arguments) NamedArguments() []string {
+	names := make([]string, len(a.args.Named))
+	for i, named := range a.args.Named {
+		names[i] = named.Name
+	}
+	return names
+}
+
+// Call calls fn, a lambda or Go func passed as an argument, with args and returns its result. It panics if fn
+// isn't a function, if it can't be called with args or if the call fails.
+func (a *Arguments) Call(fn reflect.Value, args ...reflect.Value) reflect.Value {
+	fn = indirectInterface(fn)
+	if fn.IsValid() && fn.Type() == lambdaType {
+		return a.runtime.callLambdaValues(fn.Interface().(*Lambda), args)
+	}
+	if fn.Kind() != reflect.Func {
+		a.Panicf("%s is not a function", getTypeString(fn))
+	}
+	fnType := fn.Type()
+	if fnType.IsVariadic() || fnType.NumIn() != len(args) {
+		a.Panicf("%s can't be called with %d arguments", fnType, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		v, err := convertValue(arg, fnType.In(i))
+		if err != nil {
+			a.Panicf("argument %d: %v", i, err)
+		}
+		in[i] = v
+	}
+	ret, err := callFunc(fn, in)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
 // Panicf panics with formatted error message.
 func (a *Arguments) Panicf(format string, v ...interface{}) {
 	panic(fmt.Errorf(format, v...))
@@ -99,7 +148,7 @@ func (a *Arguments) RequireNumOfArguments(funcname string, min, max int) {
 	}
 }
 
-// NumOfArguments returns the number of arguments
+// NumOfArguments returns the number of positional arguments
 func (a *Arguments) NumOfArguments() int {
 	num := len(a.args.Exprs)
 	if a.pipedVal != nil && !a.args.HasPipeSlot {
@@ -114,11 +163,13 @@ func (a *Arguments) Runtime() *Runtime {
 }
 
 // ParseInto parses the arguments into the provided pointers. It returns an error if the number of pointers passed in does not
-// equal the number of arguments, if any argument's value is invalid according to Go's reflect package, if an argument can't
-// be used as the value the pointer passed in at the corresponding position points to, or if an unhandled pointer type is encountered.
-// Allowed pointer types are pointers to interface{}, int, int64, float64, bool, string,  time.Time, reflect.Value, []interface{},
-// map[string]interface{}. If a pointer to a reflect.Value is passed in, the argument be assigned as-is to the value pointed to. For
-// pointers to int or float types, type conversion is performed automatically if necessary.
+// equal the number of arguments, if any argument's value is invalid according to Go's reflect package, or if an argument can't
+// be converted to the type the pointer passed in at the corresponding position points to. Any pointer type is allowed: numbers
+// are converted between numeric types (including unsigned and sized integers and float32) as long as the value fits, truncating
+// floats parsed into integers, strings are parsed into numbers and numbers are formatted into strings, slices and maps are converted
+// element by element, maps with string keys can be parsed into structs (matching keys to field names ignoring case), and values are
+// assigned to interfaces they implement. If a pointer to a reflect.Value is passed in, the argument is assigned as-is to the value
+// pointed to.
 func (a *Arguments) ParseInto(ptrs ...interface{}) error {
 	if len(ptrs) < a.NumOfArguments() {
 		return fmt.Errorf("have %d arguments, but only %d pointers to parse into", a.NumOfArguments(), len(ptrs))
@@ -126,79 +177,264 @@ func (a *Arguments) ParseInto(ptrs ...interface{}) error {
 
 	for i := 0; i < a.NumOfArguments(); i++ {
 		arg, ptr := indirectEface(a.Get(i)), ptrs[i]
-		ok := false
 
 		if !arg.IsValid() {
 			return fmt.Errorf("argument at position %d is not a valid value", i)
 		}
 
-		switch p := ptr.(type) {
-		case *reflect.Value:
-			*p, ok = arg, true
-		case *int:
-			switch arg.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				*p, ok = int(arg.Int()), true
-			case reflect.Float32, reflect.Float64:
-				*p, ok = int(arg.Float()), true
-			default:
-				return fmt.Errorf("could not parse %v (%s) into %v (%T)", arg, arg.Type(), ptr, ptr)
-			}
-		case *int64:
-			switch arg.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				*p, ok = arg.Int(), true
-			case reflect.Float32, reflect.Float64:
-				*p, ok = int64(arg.Float()), true
-			default:
-				return fmt.Errorf("could not parse %v (%s) into %v (%T)", arg, arg.Type(), ptr, ptr)
-			}
-		case *float64:
-			switch arg.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				*p, ok = float64(arg.Int()), true
-			case reflect.Float32, reflect.Float64:
-				*p, ok = arg.Float(), true
-			default:
-				return fmt.Errorf("could not parse %v (%s) into %v (%T)", arg, arg.Type(), ptr, ptr)
-			}
+		if p, ok := ptr.(*reflect.Value); ok {
+			*p = arg
+			continue
 		}
 
-		if ok {
-			continue
+		p := reflect.ValueOf(ptr)
+		if p.Kind() != reflect.Ptr || p.IsNil() {
+			return fmt.Errorf("trying to parse %v into %v: %T is not a non-nil pointer", arg, ptr, ptr)
 		}
 
-		if !arg.CanInterface() {
-			return fmt.Errorf("argument at position %d can't be accessed via Interface()", i)
-		}
-		val := arg.Interface()
-
-		switch p := ptr.(type) {
-		case *interface{}:
-			*p, ok = val, true
-		case *bool:
-			*p, ok = val.(bool)
-		case *string:
-			*p, ok = val.(string)
-		case *time.Time:
-			*p, ok = val.(time.Time)
-		case *[]interface{}:
-			*p, ok = val.([]interface{})
-		case *map[string]interface{}:
-			*p, ok = val.(map[string]interface{})
-		default:
-			return fmt.Errorf("trying to parse %v into %v: unhandled value type %T", arg, p, val)
+		v, err := convertValue(arg, p.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("could not parse argument at position %d into %T: %v", i, ptr, err)
+		}
+		p.Elem().Set(v)
+	}
+
+	return nil
+}
+
+// Bind parses the arguments into the fields of the struct dst points to. The positional arguments are assigned to the
+// exported fields in the order they are declared in, including the fields promoted from embedded structs; fields
+// tagged with `jet:"-"` are skipped. Named arguments are
+// assigned to the field with the same name, ignoring case. A field's name can be changed using a tag like
+// `jet:"limit"`, and `jet:"limit,required"` makes Bind return an error when the argument for the field is missing.
+// Fields without an argument keep their value, so you can set defaults before calling Bind. Arguments are converted
+// to the types of the fields like in ParseInto.
+func (a *Arguments) Bind(dst interface{}) error {
+	p := reflect.ValueOf(dst)
+	if p.Kind() != reflect.Ptr || p.IsNil() || p.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("trying to bind arguments to %T: not a non-nil pointer to a struct", dst)
+	}
+	v := p.Elem()
+	fields := bindableFields(v.Type())
+
+	num := a.NumOfArguments()
+	if num > len(fields) {
+		return fmt.Errorf("have %d arguments, but only %d fields to bind to in %s", num, len(fields), v.Type())
+	}
+
+	for i, field := range fields {
+		if i >= num {
+			break
+		}
+
+		arg := a.Get(i)
+		if !arg.IsValid() {
+			return fmt.Errorf("argument %q at position %d is not a valid value", field.name, i)
 		}
+		fv, err := convertValue(arg, field.typ)
+		if err != nil {
+			return fmt.Errorf("could not parse argument %q at position %d: %v", field.name, i, err)
+		}
+		v.FieldByIndex(field.index).Set(fv)
+	}
+
+	return a.bindNamed(v, fields, num)
+}
 
-		if !ok {
-			return fmt.Errorf("could not parse %v (%s) into %v (%T)", arg, arg.Type(), ptr, ptr)
+// bindNamed assigns the named arguments to the fields of the struct v. The first positional fields are already
+// bound to positional arguments.
+func (a *Arguments) bindNamed(v reflect.Value, fields []bindableField, positional int) error {
+	bound := make([]bool, len(fields))
+	for _, named := range a.args.Named {
+		i := 0
+		for i < len(fields) && !strings.EqualFold(fields[i].name, named.Name) {
+			i++
+		}
+		if i == len(fields) {
+			return fmt.Errorf("unknown argument %s for %s", named.Name, v.Type())
+		}
+		if i < positional {
+			return fmt.Errorf("argument %s is passed both by position and by name", named.Name)
 		}
+		arg := a.runtime.evalPrimaryExpressionGroup(named.Expression)
+		if !arg.IsValid() {
+			return fmt.Errorf("argument %s is not a valid value", named.Name)
+		}
+		fv, err := convertValue(arg, fields[i].typ)
+		if err != nil {
+			return fmt.Errorf("could not parse argument %s: %v", named.Name, err)
+		}
+		v.FieldByIndex(fields[i].index).Set(fv)
+		bound[i] = true
 	}
 
+	for i := positional; i < len(fields); i++ {
+		if fields[i].required && !bound[i] {
+			return fmt.Errorf("missing required argument %q at position %d", fields[i].name, i)
+		}
+	}
 	return nil
 }
 
+// optionsParameter reports whether named arguments can be passed to a function of type fnType: its last
+// parameter has to be a struct of options, or a pointer to one, and is not variadic.
+func optionsParameter(fnType reflect.Type) bool {
+	numIn := fnType.NumIn()
+	if numIn == 0 || fnType.IsVariadic() {
+		return false
+	}
+	typ := fnType.In(numIn - 1)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return isOptionsStruct(typ)
+}
+
+// isOptionsStruct reports whether typ is a plain struct holding options: it has no methods and no unexported
+// fields (besides embedded structs), unlike structs like time.Time which are values rather than options.
+func isOptionsStruct(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ.NumMethod() > 0 || reflect.PtrTo(typ).NumMethod() > 0 {
+		return false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			return false
+		}
+	}
+	return true
+}
+
+// options returns a copy of base, a struct or a pointer to a struct, with its fields set to the named arguments.
+func (a *Arguments) options(base reflect.Value) (reflect.Value, error) {
+	typ := base.Type()
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		if !base.IsNil() {
+			v.Elem().Set(base.Elem())
+		}
+		return v, a.bindNamed(v.Elem(), bindableFields(typ.Elem()), 0)
+	}
+	v := reflect.New(typ).Elem()
+	v.Set(base)
+	return v, a.bindNamed(v, bindableFields(typ), 0)
+}
+
 // Func function implementing this type is called directly, which is faster than calling through reflect.
 // If a function is being called many times in the execution of a template, you may consider implementing
 // a wrapper for that function implementing a Func.
 type Func func(Arguments) reflect.Value
+
+// NewFunc wraps fn, which has to be a function, into a Func. The arguments of a call are converted to the
+// types of fn's parameters: numbers are converted between numeric types as long as they fit the parameter type,
+// strings are parsed into numbers and numbers are formatted into strings, and slices and maps are converted
+// element by element. Conversion errors mention the position of the offending argument.
+//
+// Trailing parameters of fn are optional when defaults are passed: the last len(defaults) parameters (not
+// counting a variadic parameter) are set to the corresponding default value when the call doesn't provide
+// them. NewFunc panics if fn is not a function or if a default value can't be used for its parameter.
+//
+// Named arguments are assigned to the fields of the last parameter of fn, which has to be a struct of options or a
+// pointer to one, like for plain Go functions called from templates. That parameter can be omitted from calls.
+//
+// If fn returns an error as its last result and the error is not nil, the call fails with that error, reported at
+// the position of the call in the template.
+func NewFunc(fn interface{}, defaults ...interface{}) Func {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		panic(fmt.Errorf("NewFunc: %T is not a function", fn))
+	}
+	ft := fv.Type()
+
+	numIn := ft.NumIn()
+	if ft.IsVariadic() {
+		numIn--
+	}
+	if len(defaults) > numIn {
+		panic(fmt.Errorf("NewFunc: %d defaults for %s, which only has %d non-variadic parameters", len(defaults), ft, numIn))
+	}
+	numRequired := numIn - len(defaults)
+	hasOptions := optionsParameter(ft)
+	minArgs := numRequired
+	if hasOptions && len(defaults) == 0 {
+		// the parameter receiving named arguments defaults to its zero value
+		minArgs--
+	}
+
+	defaultValues := make([]reflect.Value, len(defaults))
+	for i, d := range defaults {
+		v, err := convertValue(reflect.ValueOf(d), ft.In(numRequired+i))
+		if err != nil {
+			panic(fmt.Errorf("NewFunc: default for position %d in %s: %v", numRequired+i, ft, err))
+		}
+		defaultValues[i] = v
+	}
+
+	return func(a Arguments) reflect.Value {
+		num := a.NumOfArguments()
+		if len(a.args.Named) > 0 && !hasOptions {
+			panic(funcErrorf("%s can't be called with named arguments: its last parameter is not a struct of options", ft))
+		}
+		if num < minArgs {
+			panic(funcErrorf("%s needs at least %d arguments, but have %d", ft, minArgs, num))
+		} else if num > numIn && !ft.IsVariadic() {
+			panic(funcErrorf("%s needs at most %d arguments, but have %d", ft, numIn, num))
+		}
+
+		in := make([]reflect.Value, 0, num+len(defaults))
+		for i := 0; i < num; i++ {
+			var typ reflect.Type
+			if i < numIn {
+				typ = ft.In(i)
+			} else {
+				typ = ft.In(numIn).Elem()
+			}
+			arg, err := convertValue(a.Get(i), typ)
+			if err != nil {
+				panic(funcErrorf("argument for position %d in %s: %v", i, ft, err))
+			}
+			in = append(in, arg)
+		}
+		if len(in) < numRequired {
+			in = append(in, reflect.Zero(ft.In(numIn-1)))
+		} else if len(in) < numIn {
+			in = append(in, defaultValues[len(in)-numRequired:]...)
+		}
+		if len(a.args.Named) > 0 {
+			options, err := a.options(in[numIn-1])
+			if err != nil {
+				panic(funcErrorf("%s: %v", ft, err))
+			}
+			in[numIn-1] = options
+		}
+
+		ret, err := callFunc(fv, in)
+		if err != nil {
+			panic(funcError{err})
+		}
+		return ret
+	}
+}
+
+// funcError is panicked by the Funcs returned by NewFunc when a call fails, so the error can be reported at the
+// position of the call like the errors of plain Go functions.
+type funcError struct {
+	err error
+}
+
+func funcErrorf(format string, v ...interface{}) funcError {
+	return funcError{fmt.Errorf(format, v...)}
+}
+
+// call calls fn and returns the error of a failed call to a Func returned by NewFunc.
+func (fn Func) call(a Arguments) (ret reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(funcError)
+			if !ok {
+				panic(r)
+			}
+			err = e.err
+		}
+	}()
+	return fn(a), nil
+}
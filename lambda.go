@@ -0,0 +1,157 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync/atomic"
+)
+
+// Lambda is the value of a lambda expression like x => x.Price > 10. It captures the variables, the context and
+// the loop of the scope the expression was evaluated in.
+//
+// Go functions receive lambdas as arguments like any other value and call them using Call, or get a lambda
+// converted into a Go func by declaring a parameter of func type (or using Arguments.ParseInto). Lambdas can only
+// be called while the template which created them is being executed; calling one afterwards fails.
+type Lambda struct {
+	set        *Set
+	globals    VarMap
+	formatters *formatterRegistry
+	execution  *execution
+	node       *LambdaExprNode
+	scope      *scope
+	context    reflect.Value
+	loop       *Loop
+}
+
+// execution tells the lambdas created during the execution of a template whether it finished, in which case the
+// variables they captured are not valid anymore.
+type execution struct {
+	finished int32
+}
+
+func (e *execution) finish() {
+	atomic.StoreInt32(&e.finished, 1)
+}
+
+func (e *execution) isFinished() bool {
+	return atomic.LoadInt32(&e.finished) != 0
+}
+
+var lambdaType = reflect.TypeOf((*Lambda)(nil))
+
+// NumParams returns the number of parameters of the lambda.
+func (l *Lambda) NumParams() int {
+	return len(l.node.Params)
+}
+
+// Call calls the lambda with args and returns the value of its body. It panics if the template execution which
+// created the lambda finished, if the number of arguments doesn't match the number of parameters, or if evaluating
+// the body fails. The body is evaluated using a new Runtime, so Call can be used from any goroutine, as long as
+// the template doesn't assign the variables the lambda uses meanwhile.
+func (l *Lambda) Call(args ...reflect.Value) reflect.Value {
+	st := &Runtime{
+		escapeeWriter: &escapeeWriter{Writer: io.Discard, set: l.set},
+		globals:       l.globals,
+		formatters:    l.formatters,
+		execution:     l.execution,
+	}
+	return st.callLambdaValues(l, args)
+}
+
+// callLambdaValues calls the lambda l with args, evaluating its body using st.
+func (st *Runtime) callLambdaValues(l *Lambda, args []reflect.Value) reflect.Value {
+	if l.execution.isFinished() {
+		l.node.errorf("lambda %s called after the execution of its template finished", l.node)
+	}
+	if len(args) != len(l.node.Params) {
+		l.node.errorf("lambda %s called with %d arguments, but has %d parameters", l.node, len(args), len(l.node.Params))
+	}
+	scope, context, loop := st.scope, st.context, st.currentLoop
+	defer func() { st.scope, st.context, st.currentLoop = scope, context, loop }()
+
+	st.scope, st.context, st.currentLoop = l.scope, l.context, l.loop
+	st.newScope(l.node.frame)
+	for i, param := range l.node.Params {
+		st.declareVariable(param, args[i])
+	}
+	return st.evalPrimaryExpressionGroup(l.node.Body)
+}
+
+// String returns the source of the lambda expression.
+func (l *Lambda) String() string {
+	return l.node.String()
+}
+
+func (st *Runtime) evalLambdaExpression(node *LambdaExprNode) reflect.Value {
+	if st.execution == nil {
+		st.execution = &execution{}
+	}
+	return reflect.ValueOf(&Lambda{
+		set:        st.set,
+		globals:    st.globals,
+		formatters: st.formatters,
+		execution:  st.execution,
+		node:       node,
+		scope:      st.scope,
+		context:    st.context,
+		loop:       st.currentLoop,
+	})
+}
+
+// isCallable reports whether fn can be called from a template.
+func isCallable(fn reflect.Value) bool {
+	return fn.Kind() == reflect.Func || fn.IsValid() && fn.Type() == lambdaType
+}
+
+// callLambda calls the lambda fn with the positional arguments of a call in a template.
+func (st *Runtime) callLambda(fn *Lambda, args CallArgs, pipedArg *reflect.Value) (reflect.Value, error) {
+	if len(args.Named) > 0 {
+		return reflect.Value{}, fmt.Errorf("lambda %s can't be called with named arguments", fn)
+	}
+	a := Arguments{runtime: st, args: args, pipedVal: pipedArg}
+	values := make([]reflect.Value, a.NumOfArguments())
+	for i := range values {
+		values[i] = a.Get(i)
+	}
+	if len(values) != fn.NumParams() {
+		return reflect.Value{}, fmt.Errorf("lambda %s called with %d arguments, but has %d parameters", fn, len(values), fn.NumParams())
+	}
+	return st.callLambdaValues(fn, values), nil
+}
+
+// funcOf returns a Go func of type fnType calling the lambda; fnType can't be variadic and must return at most
+// one value.
+func (l *Lambda) funcOf(fnType reflect.Type) (reflect.Value, error) {
+	if fnType.NumOut() > 1 {
+		return reflect.Value{}, fmt.Errorf("cannot use lambda %s as %s: the func returns more than one value", l, fnType)
+	}
+	if fnType.NumIn() != len(l.node.Params) || fnType.IsVariadic() {
+		return reflect.Value{}, fmt.Errorf("cannot use lambda %s as %s: the lambda has %d parameters", l, fnType, len(l.node.Params))
+	}
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		ret := l.Call(args...)
+		if fnType.NumOut() == 0 {
+			return nil
+		}
+		converted, err := convertValue(ret, fnType.Out(0))
+		if err != nil {
+			l.node.errorf("lambda %s: %v", l, err)
+		}
+		return []reflect.Value{converted}
+	}), nil
+}
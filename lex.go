@@ -16,6 +16,7 @@ package jet
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -83,6 +84,13 @@ const (
 	itemLeftBrackets
 	itemRightBrackets
 	itemUnderscore
+	itemArrow      // '=>' between the parameters and the body of a lambda
+	itemCoalesce   // '??'
+	itemOptional   // '?' of a '?.' optional field access, followed by the field
+	itemMatch      // '=~'
+	itemLeftBrace  // '{' inside action
+	itemRightBrace // '}' inside action
+	itemDotDot     // '..' of a numeric range
 	// Keywords appear after all the rest.
 	itemKeyword // used only to delimit the keywords
 	itemExtends
@@ -98,9 +106,16 @@ const (
 	itemTry
 	itemCatch
 	itemReturn
+	itemSwitch
+	itemCase
+	itemDefault
+	itemBreak
+	itemContinue
+	itemWith
 	itemAnd
 	itemOr
 	itemNot
+	itemIn
 	itemNil
 	itemMSG
 	itemTrans
@@ -136,6 +151,17 @@ var key = map[string]itemType{
 	"trans": itemTrans,
 }
 
+// statementKeywords are only keywords at the start of an action used as a statement, so they can still be used as
+// names: switch, case and with when followed by an expression, the others when they're alone in the action.
+var statementKeywords = map[string]itemType{
+	"switch":   itemSwitch,
+	"case":     itemCase,
+	"with":     itemWith,
+	"default":  itemDefault,
+	"break":    itemBreak,
+	"continue": itemContinue,
+}
+
 const eof = -1
 
 const (
@@ -162,6 +188,9 @@ type lexer struct {
 	lastPos        Pos       // position of most recent item returned by nextItem
 	items          chan item // channel of scanned items
 	parenDepth     int       // nesting depth of ( ) exprs
+	braceDepth     int       // nesting depth of { } map literals
+	interpolations []int     // brace depth at each open ${ of an interpolated string
+	actionStart    Pos       // position after the left delimiter of the current action
 	lastType       itemType
 	leftDelim      string
 	rightDelim     string
@@ -222,6 +251,13 @@ func (l *lexer) emit(t itemType) {
 	l.start = l.pos
 }
 
+// emitValue passes an item with the given value back to the client, it's used for items which don't appear
+// verbatim in the input.
+func (l *lexer) emitValue(t itemType, value string) {
+	l.lastType = t
+	l.items <- item{t, l.start, value}
+}
+
 // ignore skips over the pending input before this point.
 func (l *lexer) ignore() {
 	l.start = l.pos
@@ -356,7 +392,10 @@ func lexLeftDelim(l *lexer) stateFn {
 		l.pos += trimMarkerLen
 		l.ignore()
 	}
+	l.actionStart = l.pos
 	l.parenDepth = 0
+	l.braceDepth = 0
+	l.interpolations = l.interpolations[:0]
 	return lexInsideAction
 }
 
@@ -394,7 +433,14 @@ func lexInsideAction(l *lexer) stateFn {
 	// Spaces separate arguments; runs of spaces turn into itemSpace.
 	// Pipe symbols separate and are emitted.
 	delim, _ := l.atRightDelim()
+	if delim && (l.braceDepth > 0 || len(l.interpolations) > 0) && l.input[l.pos] == '}' {
+		// closes a map literal: {{ {"a": {"b": 1}} }}, or an interpolation: {{ `${a}}` }}
+		delim = false
+	}
 	if delim {
+		if l.braceDepth > 0 {
+			return l.errorf("unclosed left brace")
+		}
 		if l.parenDepth == 0 {
 			return lexRightDelim
 		}
@@ -451,7 +497,16 @@ func lexInsideAction(l *lexer) stateFn {
 		}
 		l.emit(itemAdd)
 	case r == '?':
-		l.emit(itemTernary)
+		switch {
+		case l.peek() == '?':
+			l.next()
+			l.emit(itemCoalesce)
+		case l.peek() == '.' && l.afterOperand() && int(l.pos)+1 < len(l.input) && (l.input[l.pos+1] < '0' || '9' < l.input[l.pos+1]):
+			// x?.field, the dot is lexed as part of the field; x ?.field and x?.5 are ternary operators
+			l.emit(itemOptional)
+		default:
+			l.emit(itemTernary)
+		}
 	case r == '&':
 		if l.next() == '&' {
 			l.emit(itemAnd)
@@ -481,9 +536,14 @@ func lexInsideAction(l *lexer) stateFn {
 		}
 
 	case r == '=':
-		if l.next() == '=' {
+		switch l.next() {
+		case '=':
 			l.emit(itemEquals)
-		} else {
+		case '>':
+			l.emit(itemArrow)
+		case '~':
+			l.emit(itemMatch)
+		default:
 			l.backup()
 			l.emit(itemAssign)
 		}
@@ -508,6 +568,11 @@ func lexInsideAction(l *lexer) stateFn {
 	case r == '\'':
 		return lexChar
 	case r == '.':
+		if l.peek() == '.' {
+			l.next()
+			l.emit(itemDotDot)
+			return lexInsideAction
+		}
 		// special look-ahead for ".field" so we don't break l.backup().
 		if l.pos < Pos(len(l.input)) {
 			r := l.input[l.pos]
@@ -532,6 +597,21 @@ func lexInsideAction(l *lexer) stateFn {
 		l.emit(itemLeftBrackets)
 	case r == ']':
 		l.emit(itemRightBrackets)
+	case r == '{':
+		l.emit(itemLeftBrace)
+		l.braceDepth++
+	case r == '}':
+		if n := len(l.interpolations); n > 0 && l.interpolations[n-1] == l.braceDepth {
+			l.interpolations = l.interpolations[:n-1]
+			l.emit(itemRightParen)
+			l.parenDepth--
+			return lexInterpolatedText
+		}
+		l.emit(itemRightBrace)
+		l.braceDepth--
+		if l.braceDepth < 0 {
+			return l.errorf("unexpected right brace %#U", r)
+		}
 	case r == '(':
 		l.emit(itemLeftParen)
 		l.parenDepth++
@@ -583,6 +663,8 @@ Loop:
 			switch {
 			case key[word] > itemKeyword:
 				l.emit(key[word])
+			case l.isStatement(word):
+				l.emit(statementKeywords[word])
 			case word[0] == '.':
 				l.emit(itemField)
 			case word == "true", word == "false":
@@ -596,6 +678,45 @@ Loop:
 	return lexInsideAction
 }
 
+// afterOperand reports whether the item being scanned directly follows an operand which can be accessed with ?.,
+// without a space in between.
+func (l *lexer) afterOperand() bool {
+	switch l.lastType {
+	case itemIdentifier, itemField, itemRightParen, itemRightBrackets:
+		return true
+	}
+	return false
+}
+
+// isStatement reports whether word, which was just scanned, is a statement keyword used as such: the first word of
+// an action, followed by an expression or by the end of the action depending on the statement.
+func (l *lexer) isStatement(word string) bool {
+	typ, ok := statementKeywords[word]
+	if !ok || strings.TrimLeftFunc(l.input[l.actionStart:l.start], isSpace) != "" {
+		return false
+	}
+	rest := strings.TrimLeftFunc(l.input[l.pos:], isSpace)
+	switch typ {
+	case itemSwitch, itemCase, itemWith:
+		return len(rest) < len(l.input[l.pos:]) && startsOperand(rest)
+	}
+	return strings.HasPrefix(rest, l.rightDelim) || strings.HasPrefix(rest, strings.TrimLeftFunc(l.trimRightDelim, isSpace))
+}
+
+// startsOperand reports whether s starts with an operand, rather than with an operator or the end of the action.
+func startsOperand(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	switch r {
+	case '.', '"', '`', '\'', '(', '[', '{':
+		return true
+	case '!', '-', '+':
+		// unary operators are followed by their operand, binary ones by a space or another operator character
+		next, _ := utf8.DecodeRuneInString(s[size:])
+		return isAlphaNumeric(next) || next == '.' || next == '('
+	}
+	return isAlphaNumeric(r)
+}
+
 // lexField scans a field: .Alphanumeric.
 // The . has been scanned.
 func lexField(l *lexer) stateFn {
@@ -631,7 +752,7 @@ func (l *lexer) atTerminator() bool {
 		return true
 	}
 	switch r {
-	case eof, '.', ',', '|', ':', ')', '=', '(', ';', '?', '[', ']', '+', '-', '/', '%', '*', '&', '!', '<', '>':
+	case eof, '.', ',', '|', ':', ')', '=', '(', ';', '?', '[', ']', '{', '}', '+', '-', '/', '%', '*', '&', '!', '<', '>':
 		return true
 	}
 	// Does r start the delimiter? This can be ambiguous (with delim=="//", $x/2 will
@@ -686,7 +807,8 @@ func (l *lexer) scanNumber() bool {
 		digits = "0123456789abcdefABCDEF"
 	}
 	l.acceptRun(digits)
-	if l.accept(".") {
+	// the dot of 1..10 isn't a decimal point
+	if !strings.HasPrefix(l.input[l.pos:], "..") && l.accept(".") {
 		l.acceptRun(digits)
 	}
 	if l.accept("eE") {
@@ -725,6 +847,15 @@ Loop:
 
 // lexRawQuote scans a raw quoted string.
 func lexRawQuote(l *lexer) stateFn {
+	// a backtick can only appear inside an interpolation, so the string contains one if there's a ${ before the
+	// first backtick
+	if end := strings.IndexByte(l.input[l.pos:], '`'); end >= 0 && strings.Contains(l.input[l.pos:int(l.pos)+end], "${") {
+		l.emitValue(itemLeftParen, "(")
+		l.parenDepth++
+		l.emitValue(itemString, `""`)
+		l.ignore()
+		return lexInterpolatedText
+	}
 Loop:
 	for {
 		switch l.next() {
@@ -738,6 +869,56 @@ Loop:
 	return lexInsideAction
 }
 
+// lexInterpolatedText scans the text of a raw quoted string containing ${expr} interpolations, up to the next
+// interpolation or the closing quote. The string is lexed as a parenthesized concatenation, `a ${b} c` produces
+// the same items as ("" + "a " + (b) + " c"), and $${ is a literal ${. The interpolated expressions are scanned by
+// lexInsideAction, so they can contain braces and strings (even other raw quoted strings) like any other expression.
+func lexInterpolatedText(l *lexer) stateFn {
+	var text strings.Builder
+	for {
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], "$${"):
+			text.WriteString("${")
+			l.pos += 3
+			continue
+		case strings.HasPrefix(l.input[l.pos:], "${"):
+			l.emitText(text.String())
+			l.pos += 2
+			if strings.HasPrefix(strings.TrimLeft(l.input[l.pos:], " \t\r\n"), "}") {
+				return l.errorf("empty interpolation in raw quoted string")
+			}
+			l.emitValue(itemAdd, "+")
+			l.emitValue(itemLeftParen, "${")
+			l.ignore()
+			l.parenDepth++
+			l.interpolations = append(l.interpolations, l.braceDepth)
+			return lexInsideAction
+		}
+		switch r := l.next(); r {
+		case eof:
+			return l.errorf("unterminated raw quoted string")
+		case '`':
+			l.emitText(text.String())
+			l.emitValue(itemRightParen, ")")
+			l.ignore()
+			l.parenDepth--
+			return lexInsideAction
+		case '\r':
+			// dropped, like in Go raw strings
+		default:
+			text.WriteRune(r)
+		}
+	}
+}
+
+// emitText emits the text of an interpolated string as a quoted string added to the previous items.
+func (l *lexer) emitText(text string) {
+	if text != "" {
+		l.emitValue(itemAdd, "+")
+		l.emitValue(itemString, strconv.Quote(text))
+	}
+}
+
 // isSpace reports whether r is a space character.
 func isSpace(r rune) bool {
 	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
@@ -67,6 +67,24 @@ func TestLexer(t *testing.T) {
 	lexerTestCase(t, `{{Ex:=1}}`, itemLeftDelim, itemIdentifier, itemAssign, itemNumber, itemRightDelim)
 	lexerTestCase(t, `{{.Ex!1}}`, itemLeftDelim, itemField, itemNot, itemNumber, itemRightDelim)
 	lexerTestCase(t, `{{.Ex==1}}`, itemLeftDelim, itemField, itemEquals, itemNumber, itemRightDelim)
+	lexerTestCase(t, `{{x=>x}}`, itemLeftDelim, itemIdentifier, itemArrow, itemIdentifier, itemRightDelim)
+	lexerTestCase(t, `{{x??.Ex}}`, itemLeftDelim, itemIdentifier, itemCoalesce, itemField, itemRightDelim)
+	lexerTestCase(t, `{{x?.Ex?.Ey}}`, itemLeftDelim, itemIdentifier, itemOptional, itemField, itemOptional, itemField, itemRightDelim)
+	lexerTestCase(t, `{{x ?.Ex : y}}`, itemLeftDelim, itemIdentifier, itemTernary, itemField, itemColon, itemIdentifier, itemRightDelim)
+	lexerTestCase(t, `{{x=~"a"}}`, itemLeftDelim, itemIdentifier, itemMatch, itemString, itemRightDelim)
+	lexerTestCase(t, `{{x in y}}`, itemLeftDelim, itemIdentifier, itemIdentifier, itemIdentifier, itemRightDelim)
+	lexerTestCase(t, `{{ switch x }}{{ case 1 }}{{- default -}}`, itemLeftDelim, itemSwitch, itemIdentifier, itemRightDelim, itemLeftDelim, itemCase, itemNumber, itemRightDelim, itemLeftDelim, itemDefault, itemRightDelim)
+	lexerTestCase(t, `{{ switch := with }}{{ break(default) }}`, itemLeftDelim, itemIdentifier, itemAssign, itemIdentifier, itemRightDelim, itemLeftDelim, itemIdentifier, itemLeftParen, itemIdentifier, itemRightParen, itemRightDelim)
+	lexerTestCase(t, `{{ range x }}{{ continue -}}{{ end }}`, itemLeftDelim, itemRange, itemIdentifier, itemRightDelim, itemLeftDelim, itemContinue, itemRightDelim, itemLeftDelim, itemEnd, itemRightDelim)
+	lexerTestCase(t, `{{ {"a": {"b": [1]}} }}`, itemLeftDelim, itemLeftBrace, itemString, itemColon, itemLeftBrace, itemString, itemColon, itemLeftBrackets, itemNumber, itemRightBrackets, itemRightBrace, itemRightBrace, itemRightDelim)
+	lexerTestCase(t, "{{ `a ${b}}` }}", itemLeftDelim, itemLeftParen, itemString, itemAdd, itemString, itemAdd, itemLeftParen, itemIdentifier, itemRightParen, itemAdd, itemString, itemRightParen, itemRightDelim)
+	lexerTestCase(t, "{{ `a $b {c}` }}", itemLeftDelim, itemRawString, itemRightDelim)
+	lexerTestCase(t, "{{ `${ `b` }` }}", itemLeftDelim, itemLeftParen, itemString, itemAdd, itemLeftParen, itemRawString, itemRightParen, itemRightParen, itemRightDelim)
+	lexerTestCase(t, "{{ `${ {\"b\": 1} }` }}", itemLeftDelim, itemLeftParen, itemString, itemAdd, itemLeftParen, itemLeftBrace, itemString, itemColon, itemNumber, itemRightBrace, itemRightParen, itemRightParen, itemRightDelim)
+	lexerTestCase(t, `{{1..10}}`, itemLeftDelim, itemNumber, itemDotDot, itemNumber, itemRightDelim)
+	lexerTestCase(t, `{{a..-b.c}}`, itemLeftDelim, itemIdentifier, itemDotDot, itemMinus, itemIdentifier, itemField, itemRightDelim)
+	lexerTestCase(t, `{{1.5..2}}`, itemLeftDelim, itemNumber, itemDotDot, itemNumber, itemRightDelim)
+	lexerTestCase(t, `{{x?.5:1}}`, itemLeftDelim, itemIdentifier, itemTernary, itemNumber, itemColon, itemNumber, itemRightDelim)
 	lexerTestCase(t, `{{.Ex&&1}}`, itemLeftDelim, itemField, itemAnd, itemNumber, itemRightDelim)
 	lexerTestCase(t, `{{ _ = foo }}`, itemLeftDelim, itemUnderscore, itemAssign, itemIdentifier, itemRightDelim)
 }
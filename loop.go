@@ -0,0 +1,124 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Loop describes the current iteration of a range loop. It's available as the variable loop in range bodies,
+// and its fields are accessed with lower case names in templates, e.g. loop.index or loop.parent.last.
+type Loop struct {
+	Index  int   // index of the iteration, starting at 0
+	Index1 int   // index of the iteration, starting at 1
+	First  bool  // whether this is the first iteration
+	Last   bool  // whether this is the last iteration, only set once loop.last is read or IsLast is called
+	Length int   // number of iterations, or -1 if the ranger can't tell before ranging
+	Odd    bool  // whether Index1 is odd: true for the first, third, ... iteration
+	Even   bool  // whether Index1 is even
+	Depth  int   // nesting level of the loop, starting at 1
+	Parent *Loop // the enclosing loop, or nil
+
+	ranger               Ranger // ranger of the loop, nil once the range is done
+	peeked               bool   // whether the next iteration was fetched from the ranger to know if this is the last
+	nextIndex, nextValue reflect.Value
+	end                  bool
+}
+
+// lastReporter is implemented by the rangers which can tell whether they produced their last iteration without
+// fetching the next one.
+type lastReporter interface {
+	last() bool
+}
+
+var loopType = reflect.TypeOf(Loop{})
+
+func init() {
+	// register the lower case field names of Loop in the struct cache used to resolve fields
+	cache := make(map[string][]int)
+	buildCache(loopType, cache, nil)
+	for name, index := range cache {
+		cache[strings.ToLower(name[:1])+name[1:]] = index
+	}
+	cachedStructsFieldIndex[loopType] = cache
+}
+
+// newLoop returns the loop for ranging over value with ranger, nested in parent.
+func newLoop(value reflect.Value, ranger Ranger, parent *Loop) *Loop {
+	loop := &Loop{Index: -1, Length: -1, Depth: 1, Parent: parent}
+	if parent != nil {
+		loop.Depth = parent.Depth + 1
+	}
+	if r, ok := ranger.(*intsRanger); ok {
+		loop.Length = int(r.to - r.val - 1)
+	} else if r, ok := ranger.(*numberRanger); ok {
+		loop.Length = int(r.count)
+	} else if v, _ := indirect(value); v.Kind() == reflect.Slice || v.Kind() == reflect.Array || v.Kind() == reflect.Map {
+		if _, builtin := ranger.(pooledRanger); builtin {
+			loop.Length = v.Len()
+		}
+	}
+	return loop
+}
+
+// next moves the loop to the next iteration.
+func (l *Loop) next() {
+	l.Index++
+	l.Index1 = l.Index + 1
+	l.First = l.Index == 0
+	l.Last = false
+	l.Odd = l.Index1%2 == 1
+	l.Even = !l.Odd
+	l.peeked = false
+}
+
+// IsLast sets Last and reports whether this is the last iteration. Unless the ranger can tell, the next iteration
+// is fetched from it, which blocks when ranging over a channel until the next value is sent or the channel is
+// closed. Templates call it when reading loop.last, so loops which don't use it never look ahead.
+func (l *Loop) IsLast() bool {
+	if l.ranger == nil || l.peeked {
+		return l.Last
+	}
+	if r, ok := l.ranger.(lastReporter); ok {
+		l.Last = r.last()
+		return l.Last
+	}
+	l.nextIndex, l.nextValue, l.end = l.ranger.Range()
+	l.peeked = true
+	l.Last = l.end
+	return l.Last
+}
+
+// snapshot returns a copy of l and its parents which doesn't use their rangers, for async includes executed in
+// another goroutine. Since the rangers can't be used concurrently, the last iterations are looked for beforehand.
+func (l *Loop) snapshot() *Loop {
+	if l == nil {
+		return nil
+	}
+	l.IsLast()
+	snapshot := *l
+	snapshot.ranger = nil
+	snapshot.Parent = l.Parent.snapshot()
+	return &snapshot
+}
+
+// advance returns the next iteration of the ranger of the loop, which IsLast might have fetched already.
+func (l *Loop) advance() (index, value reflect.Value, end bool) {
+	if l.peeked {
+		return l.nextIndex, l.nextValue, l.end
+	}
+	return l.ranger.Range()
+}
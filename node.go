@@ -18,6 +18,9 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 )
 
 var textFormat = "%s" //Changed to "%q" in tests for better error messages.
@@ -29,6 +32,7 @@ type Node interface {
 	line() int
 	error(error)
 	errorf(string, ...interface{})
+	compiled() evalFunc
 }
 
 type Expression interface {
@@ -51,12 +55,18 @@ type NodeBase struct {
 	Line         int
 	NodeType
 	Pos
+
+	eval evalFunc // set when the node was compiled
 }
 
 func (node *NodeBase) line() int {
 	return node.Line
 }
 
+func (node *NodeBase) compiled() evalFunc {
+	return node.eval
+}
+
 func (node *NodeBase) error(err error) {
 	node.errorf("%s", err)
 }
@@ -109,7 +119,17 @@ const (
 	NodeTernaryExpr
 	NodeIndexExpr
 	NodeSliceExpr
+	NodeLambdaExpr
+	NodeCoalesceExpr
+	NodeMapLiteral
+	NodeSliceLiteral
 	endExpressions
+	NodeWith     //A with action.
+	NodeSwitch   //A switch action.
+	NodeCase     //A case of a switch action.
+	nodeDefault  //A default action. Not added to tree.
+	NodeBreak    //A break action.
+	NodeContinue //A continue action.
 )
 
 // Nodes.
@@ -118,6 +138,9 @@ const (
 type ListNode struct {
 	NodeBase
 	Nodes []Node //The element nodes in lexical order.
+
+	exec  evalFunc     // executes the compiled nodes
+	frame *frameLayout // variables declared by let statements in the list
 }
 
 func (l *ListNode) append(n Node) {
@@ -193,20 +216,15 @@ func (c *CommandNode) String() string {
 		return c.BaseExpr.String()
 	}
 
-	arguments := ""
-	for i, expr := range c.Exprs {
-		if i > 0 {
-			arguments += ", "
-		}
-		arguments += expr.String()
-	}
-	return fmt.Sprintf("%s(%s)", c.BaseExpr, arguments)
+	return fmt.Sprintf("%s(%s)", c.BaseExpr, c.arguments())
 }
 
 // IdentifierNode holds an identifier.
 type IdentifierNode struct {
 	NodeBase
 	Ident string //The identifier's name.
+
+	slot variableSlot // where the variable lives, if it's declared in the template
 }
 
 func (i *IdentifierNode) String() string {
@@ -254,8 +272,9 @@ func (f *FieldNode) String() string {
 // The periods are dropped from each ident.
 type ChainNode struct {
 	NodeBase
-	Node  Node
-	Field []string //The identifiers in lexical order.
+	Node     Node
+	Field    []string //The identifiers in lexical order.
+	Optional []bool   //Whether each field is accessed using '?.'; nil if none is.
 }
 
 // Add adds the named field (which should start with a period) to the end of the chain.
@@ -268,6 +287,24 @@ func (c *ChainNode) Add(field string) {
 		panic("empty field")
 	}
 	c.Field = append(c.Field, field)
+	if c.Optional != nil {
+		c.Optional = append(c.Optional, false)
+	}
+}
+
+// AddOptional adds the named field (which should start with a period) to the end of the chain, accessed using '?.':
+// evaluating the chain results in nil if the value the field is accessed on is nil.
+func (c *ChainNode) AddOptional(field string) {
+	if c.Optional == nil {
+		c.Optional = make([]bool, len(c.Field))
+	}
+	c.Add(field)
+	c.Optional[len(c.Optional)-1] = true
+}
+
+// optional reports whether the field at index i is accessed using '?.'.
+func (c *ChainNode) optional(i int) bool {
+	return i < len(c.Optional) && c.Optional[i]
 }
 
 func (c *ChainNode) String() string {
@@ -275,7 +312,10 @@ func (c *ChainNode) String() string {
 	if _, ok := c.Node.(*PipeNode); ok {
 		s = "(" + s + ")"
 	}
-	for _, field := range c.Field {
+	for i, field := range c.Field {
+		if c.optional(i) {
+			s += "?"
+		}
 		s += "." + field
 	}
 	return s
@@ -311,6 +351,21 @@ type NumberNode struct {
 	Text       string     //The original textual representation from the input.
 }
 
+// value returns the value of the number when evaluated: like in the Go text/template package, numbers which can
+// be represented as a float64 (all integer literals, too) evaluate to a float64.
+func (node *NumberNode) value() reflect.Value {
+	if node.IsFloat {
+		return reflect.ValueOf(&node.Float64).Elem()
+	}
+	if node.IsInt {
+		return reflect.ValueOf(&node.Int64).Elem()
+	}
+	if node.IsUint {
+		return reflect.ValueOf(&node.Uint64).Elem()
+	}
+	return reflect.Value{}
+}
+
 // simplifyComplex pulls out any other types that are represented by the complex number.
 // These all require that the imaginary part be zero.
 func (n *NumberNode) simplifyComplex() {
@@ -373,11 +428,21 @@ func (e *elseNode) String() string {
 	return "{{else}}"
 }
 
+// defaultNode represents a {{default}} action. Does not appear in the final tree.
+type defaultNode struct {
+	NodeBase
+}
+
+func (d *defaultNode) String() string {
+	return "{{default}}"
+}
+
 // SetNode represents a set action, ident( ',' ident)* '=' expression ( ',' expression )*
 type SetNode struct {
 	NodeBase
 	Let                bool
 	IndexExprGetLookup bool
+	MultiValueCall     bool // all return values of the single call on the right are assigned
 	Left               []Expression
 	Right              []Expression
 }
@@ -415,11 +480,17 @@ type BranchNode struct {
 	Expression Expression
 	List       *ListNode
 	ElseList   *ListNode
+
+	frame *frameLayout // variables declared by Set, if it's a let statement
 }
 
 func (b *BranchNode) String() string {
 
-	if b.NodeType == NodeRange {
+	if b.NodeType == NodeRange || b.NodeType == NodeWith {
+		keyword := "range"
+		if b.NodeType == NodeWith {
+			keyword = "with"
+		}
 		s := ""
 		if b.Set != nil {
 			s = b.Set.String()
@@ -428,9 +499,9 @@ func (b *BranchNode) String() string {
 		}
 
 		if b.ElseList != nil {
-			return fmt.Sprintf("{{range %s}}%s{{else}}%s{{end}}", s, b.List, b.ElseList)
+			return fmt.Sprintf("{{%s %s}}%s{{else}}%s{{end}}", keyword, s, b.List, b.ElseList)
 		}
-		return fmt.Sprintf("{{range %s}}%s{{end}}", s, b.List)
+		return fmt.Sprintf("{{%s %s}}%s{{end}}", keyword, s, b.List)
 	} else {
 		s := ""
 		if b.Set != nil {
@@ -451,6 +522,110 @@ type IfNode struct {
 // RangeNode represents a {{range}} action and its commands.
 type RangeNode struct {
 	BranchNode
+	To      Expression // end of a numeric range like 1..10, whose start is the range expression; nil otherwise
+	Step    Expression // step of a numeric range, or nil
+	Where   Expression // condition of the where modifier, or nil
+	Reverse bool       // whether the reverse modifier is set
+	Offset  Expression // argument of the offset modifier, or nil
+	Limit   Expression // argument of the limit modifier, or nil
+	Batch   Expression // argument of the batch modifier, or nil
+}
+
+// clause returns the range clause of the node: the range expression or assignment, followed by the end and step
+// of a numeric range and by the modifiers.
+func (r *RangeNode) clause() string {
+	var clause string
+	if r.Set != nil {
+		clause = r.Set.String()
+	} else {
+		clause = r.Expression.String()
+	}
+	if r.To != nil {
+		clause += ".." + r.To.String()
+		if r.Step != nil {
+			clause += " step " + r.Step.String()
+		}
+	}
+	if r.Where != nil {
+		clause += " where " + r.Where.String()
+	}
+	if r.Reverse {
+		clause += " reverse"
+	}
+	if r.Offset != nil {
+		clause += " offset " + r.Offset.String()
+	}
+	if r.Limit != nil {
+		clause += " limit " + r.Limit.String()
+	}
+	if r.Batch != nil {
+		clause += " batch " + r.Batch.String()
+	}
+	return clause
+}
+
+func (r *RangeNode) String() string {
+	if r.ElseList != nil {
+		return fmt.Sprintf("{{range %s}}%s{{else}}%s{{end}}", r.clause(), r.List, r.ElseList)
+	}
+	return fmt.Sprintf("{{range %s}}%s{{end}}", r.clause(), r.List)
+}
+
+// WithNode represents a {{with}} action and its commands.
+type WithNode struct {
+	BranchNode
+}
+
+// SwitchNode represents a {{switch}} action and its cases.
+type SwitchNode struct {
+	NodeBase
+	Set        *SetNode
+	Expression Expression
+	Cases      []*CaseNode
+	Default    *ListNode // nil if there is no {{default}}
+
+	frame *frameLayout // variables declared by Set, if it's a let statement
+}
+
+func (s *SwitchNode) String() string {
+	b := new(bytes.Buffer)
+	b.WriteString("{{switch ")
+	if s.Set != nil {
+		fmt.Fprintf(b, "%s;", s.Set)
+	}
+	fmt.Fprintf(b, "%s}}", s.Expression)
+	for _, c := range s.Cases {
+		fmt.Fprint(b, c)
+	}
+	if s.Default != nil {
+		fmt.Fprintf(b, "{{default}}%s", s.Default)
+	}
+	b.WriteString("{{end}}")
+	return b.String()
+}
+
+// CaseNode represents a {{case}} of a switch action: its list is executed when the switch expression equals
+// one of the case expressions.
+type CaseNode struct {
+	NodeBase
+	Expressions []Expression
+	List        *ListNode
+}
+
+func (c *CaseNode) String() string {
+	b := new(bytes.Buffer)
+	b.WriteString("{{case ")
+	for i, expr := range c.Expressions {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprint(b, expr)
+	}
+	b.WriteString("}}")
+	if c.List != nil {
+		fmt.Fprint(b, c.List)
+	}
+	return b.String()
 }
 
 type BlockParameter struct {
@@ -502,6 +677,8 @@ type BlockNode struct {
 
 	List    *ListNode
 	Content *ListNode
+
+	frame *frameLayout // the block's parameters
 }
 
 func (t *BlockNode) String() string {
@@ -553,13 +730,18 @@ type IncludeNode struct {
 	NodeBase
 	Name    Expression
 	Context Expression
+	Async   bool // render the included template in its own goroutine
 }
 
 func (t *IncludeNode) String() string {
+	async := ""
+	if t.Async {
+		async = " async"
+	}
 	if t.Context == nil {
-		return fmt.Sprintf("{{include %s}}", t.Name)
+		return fmt.Sprintf("{{include %s%s}}", t.Name, async)
 	}
-	return fmt.Sprintf("{{include %s %s}}", t.Name, t.Context)
+	return fmt.Sprintf("{{include %s %s%s}}", t.Name, t.Context, async)
 }
 
 type binaryExprNode struct {
@@ -591,9 +773,11 @@ type LogicalExprNode struct {
 }
 
 // ComparativeExprNode represents a comparative expression
-// ex: expression ( '==' | '!=' ) expression
+// ex: expression ( '==' | '!=' | 'in' | '=~' ) expression
 type ComparativeExprNode struct {
 	binaryExprNode
+
+	pattern atomic.Value // *cachedPattern, the last regular expression matched using '=~'
 }
 
 // NumericComparativeExprNode represents a numeric comparative expression
@@ -602,6 +786,12 @@ type NumericComparativeExprNode struct {
 	binaryExprNode
 }
 
+// CoalesceExprNode represents a null-coalescing expression, which evaluates to Right if Left is unset or nil
+// ex: expression '??' expression
+type CoalesceExprNode struct {
+	binaryExprNode
+}
+
 // NotExprNode represents a negate expression
 // ex: '!' expression
 type NotExprNode struct {
@@ -615,9 +805,34 @@ func (s *NotExprNode) String() string {
 
 type CallArgs struct {
 	Exprs       []Expression
+	Named       []NamedArg // arguments passed by name, which follow the positional arguments
 	HasPipeSlot bool
 }
 
+// NamedArg is an argument passed by name in a call, like limit: 10 in fn(items, limit: 10).
+type NamedArg struct {
+	Name       string
+	Expression Expression
+}
+
+// arguments formats the positional and named arguments, separated by commas.
+func (c *CallArgs) arguments() string {
+	arguments := ""
+	for i, expr := range c.Exprs {
+		if i > 0 {
+			arguments += ", "
+		}
+		arguments += expr.String()
+	}
+	for i, named := range c.Named {
+		if i > 0 || len(c.Exprs) > 0 {
+			arguments += ", "
+		}
+		arguments += named.Name + ": " + named.Expression.String()
+	}
+	return arguments
+}
+
 // CallExprNode represents a call expression
 // ex: expression '(' (expression (',' expression)* )? ')'
 type CallExprNode struct {
@@ -627,14 +842,7 @@ type CallExprNode struct {
 }
 
 func (s *CallExprNode) String() string {
-	arguments := ""
-	for i, expr := range s.Exprs {
-		if i > 0 {
-			arguments += ", "
-		}
-		arguments += expr.String()
-	}
-	return fmt.Sprintf("%s(%s)", s.BaseExpr, arguments)
+	return fmt.Sprintf("%s(%s)", s.BaseExpr, s.arguments())
 }
 
 // TernaryExprNod represents a ternary expression,
@@ -676,6 +884,58 @@ func (s *SliceExprNode) String() string {
 	return fmt.Sprintf("%s[%s:%s]", s.Base, index_string, len_string)
 }
 
+// MapLiteralNode represents a map literal, which evaluates to a map[string]interface{}
+// ex: '{' (expression ':' expression (',' expression ':' expression)*)? '}'
+type MapLiteralNode struct {
+	NodeBase
+	Keys   []Expression
+	Values []Expression
+}
+
+func (m *MapLiteralNode) String() string {
+	entries := make([]string, len(m.Keys))
+	for i, key := range m.Keys {
+		entries[i] = fmt.Sprintf("%s: %s", key, m.Values[i])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// SliceLiteralNode represents a slice literal, which evaluates to a []interface{}
+// ex: '[' (expression (',' expression)*)? ']'
+type SliceLiteralNode struct {
+	NodeBase
+	Items []Expression
+}
+
+func (s *SliceLiteralNode) String() string {
+	items := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		items[i] = item.String()
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// LambdaExprNode represents an anonymous function expression
+// ex: identifier '=>' expression | '(' (identifier (',' identifier)*)? ')' '=>' expression
+type LambdaExprNode struct {
+	NodeBase
+	Params []*IdentifierNode
+	Body   Expression
+
+	frame *frameLayout // the lambda's parameters
+}
+
+func (l *LambdaExprNode) String() string {
+	if len(l.Params) == 1 {
+		return fmt.Sprintf("%s => %s", l.Params[0], l.Body)
+	}
+	params := make([]string, len(l.Params))
+	for i, param := range l.Params {
+		params[i] = param.String()
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(params, ", "), l.Body)
+}
+
 type ReturnNode struct {
 	NodeBase
 	Value Expression
@@ -685,6 +945,24 @@ func (n *ReturnNode) String() string {
 	return fmt.Sprintf("return %v", n.Value)
 }
 
+// BreakNode represents a {{break}} action, which ends the innermost range loop.
+type BreakNode struct {
+	NodeBase
+}
+
+func (b *BreakNode) String() string {
+	return "{{break}}"
+}
+
+// ContinueNode represents a {{continue}} action, which skips to the next iteration of the innermost range loop.
+type ContinueNode struct {
+	NodeBase
+}
+
+func (c *ContinueNode) String() string {
+	return "{{continue}}"
+}
+
 type TryNode struct {
 	NodeBase
 	List  *ListNode
@@ -702,6 +980,8 @@ type catchNode struct {
 	NodeBase
 	Err  *IdentifierNode
 	List *ListNode
+
+	frame *frameLayout // the error variable
 }
 
 func (n *catchNode) String() string {
@@ -0,0 +1,92 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import "reflect"
+
+// AddOperator is implemented by types defining the + operator in templates. When the left operand of a + expression
+// implements AddOperator, the result of the expression is the result of JetAdd called with the right operand,
+// instead of a numeric addition or a string concatenation. An error returned by JetAdd fails the template.
+type AddOperator interface {
+	JetAdd(other reflect.Value) (reflect.Value, error)
+}
+
+// SubOperator is implemented by types defining the binary - operator in templates, like AddOperator.
+type SubOperator interface {
+	JetSub(other reflect.Value) (reflect.Value, error)
+}
+
+// MulOperator is implemented by types defining the * operator in templates, like AddOperator.
+type MulOperator interface {
+	JetMul(other reflect.Value) (reflect.Value, error)
+}
+
+// DivOperator is implemented by types defining the / operator in templates, like AddOperator.
+type DivOperator interface {
+	JetDiv(other reflect.Value) (reflect.Value, error)
+}
+
+// ModOperator is implemented by types defining the % operator in templates, like AddOperator.
+type ModOperator interface {
+	JetMod(other reflect.Value) (reflect.Value, error)
+}
+
+var (
+	addOperatorType = reflect.TypeOf((*AddOperator)(nil)).Elem()
+	subOperatorType = reflect.TypeOf((*SubOperator)(nil)).Elem()
+	mulOperatorType = reflect.TypeOf((*MulOperator)(nil)).Elem()
+	divOperatorType = reflect.TypeOf((*DivOperator)(nil)).Elem()
+	modOperatorType = reflect.TypeOf((*ModOperator)(nil)).Elem()
+)
+
+// overloadedOperator computes left op right if left (or a pointer to left, if it's addressable) implements the
+// interface defining op. It returns false if it doesn't.
+func overloadedOperator(op itemType, left, right reflect.Value) (result reflect.Value, ok bool, err error) {
+	if !left.IsValid() || !left.CanInterface() {
+		return
+	}
+	var iface reflect.Type
+	switch op {
+	case itemAdd:
+		iface = addOperatorType
+	case itemMinus:
+		iface = subOperatorType
+	case itemMul:
+		iface = mulOperatorType
+	case itemDiv:
+		iface = divOperatorType
+	case itemMod:
+		iface = modOperatorType
+	}
+	if !left.Type().Implements(iface) {
+		if !left.CanAddr() || !reflect.PtrTo(left.Type()).Implements(iface) {
+			return
+		}
+		left = left.Addr()
+	}
+	switch operand := left.Interface(); op {
+	case itemAdd:
+		result, err = operand.(AddOperator).JetAdd(right)
+	case itemMinus:
+		result, err = operand.(SubOperator).JetSub(right)
+	case itemMul:
+		result, err = operand.(MulOperator).JetMul(right)
+	case itemDiv:
+		result, err = operand.(DivOperator).JetDiv(right)
+	case itemMod:
+		result, err = operand.(ModOperator).JetMod(right)
+	}
+	return result, true, err
+}
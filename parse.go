@@ -42,9 +42,11 @@ type Template struct {
 	text string // text parsed to create the template (or its parent)
 
 	// Parsing only; cleared after parse.
-	lex       *lexer
-	token     [3]item // three-token lookahead for parser.
-	peekCount int
+	lex         *lexer
+	token       [3]item // three-token lookahead for parser.
+	peekCount   int
+	loopDepth   int // number of enclosing range bodies, where break and continue are allowed.
+	switchDepth int // number of enclosing switch bodies, where {{default}} is a keyword.
 }
 
 func (t *Template) String() (template string) {
@@ -178,6 +180,8 @@ func (t *Template) expectOneOf(expected1, expected2 itemType, context, expectedA
 // unexpected complains about the token and terminates processing.
 func (t *Template) unexpected(token item, context, expected string) {
 	switch {
+	case token.typ == itemError:
+		t.errorf("%s", token.val)
 	case token.typ == itemImport,
 		token.typ == itemExtends:
 		t.errorf("parsing %s: unexpected keyword '%s' ('%s' statements must be at the beginning of the template)", context, token.val, token.val)
@@ -220,6 +224,8 @@ func (s *Set) parse(name, text string, cacheAfterParsing bool) (t *Template, err
 	t.startParse(lexer)
 	t.parseTemplate(cacheAfterParsing)
 	t.stopParse()
+	t.resolveVariables()
+	t.compile()
 
 	if t.extends != nil {
 		t.addBlocks(t.extends.processedBlocks)
@@ -290,6 +296,8 @@ func (t *Template) parseTemplate(cacheAfterParsing bool) (next Node) {
 		switch n := t.textOrAction(); n.Type() {
 		case nodeEnd, nodeElse, nodeContent:
 			t.errorf("unexpected %s", n)
+		case NodeCase, nodeDefault:
+			t.errorf("unexpected %s outside of switch", n)
 		default:
 			t.Root.append(n)
 		}
@@ -315,6 +323,9 @@ func IsEmptyTree(n Node) bool {
 		return true
 	case *ActionNode:
 	case *IfNode:
+	case *SwitchNode:
+	case *WithNode:
+	case *BreakNode, *ContinueNode:
 	case *ListNode:
 		for _, node := range n.Nodes {
 			if !IsEmptyTree(node) {
@@ -396,12 +407,16 @@ func (t *Template) parseBlock() Node {
 
 	t.expectRightDelim(context)
 
+	// blocks are executed where they are yielded, so they can't break out of a range they are defined in
+	loopDepth, switchDepth := t.loopDepth, t.switchDepth
+	t.loopDepth, t.switchDepth = 0, 0
 	list, end := t.itemList(nodeContent, nodeEnd)
 	var contentList *ListNode
 
 	if end.Type() == nodeContent {
 		contentList, _ = t.itemList(nodeEnd)
 	}
+	t.loopDepth, t.switchDepth = loopDepth, switchDepth
 
 	block := t.newBlock(name.pos, t.lex.lineNumber(), name.val, bplist, pipe, list, contentList)
 	t.passedBlocks[block.Name] = block
@@ -451,7 +466,10 @@ func (t *Template) parseYield() Node {
 			// parse content from following nodes (until {{end}})
 			t.nextNonSpace()
 			t.expectRightDelim(context)
+			loopDepth, switchDepth := t.loopDepth, t.switchDepth
+			t.loopDepth, t.switchDepth = 0, 0
 			content, _ = t.itemList(nodeEnd)
+			t.loopDepth, t.switchDepth = loopDepth, switchDepth
 		default:
 			t.unexpected(t.nextNonSpace(), context, "content keyword or closing delimiter")
 		}
@@ -463,11 +481,28 @@ func (t *Template) parseYield() Node {
 func (t *Template) parseInclude() Node {
 	var context Expression
 	name := t.expression("include", "template name")
-	if t.peekNonSpace().typ != itemRightDelim {
+	async := t.asyncModifier()
+	if !async && t.peekNonSpace().typ != itemRightDelim {
 		context = t.expression("include", "context")
+		async = t.asyncModifier()
 	}
 	t.expectRightDelim("include invocation")
-	return t.newInclude(name.Position(), t.lex.lineNumber(), name, context)
+	return t.newInclude(name.Position(), t.lex.lineNumber(), name, context, async)
+}
+
+// asyncModifier consumes the async modifier of an include, if it's next: async isn't a keyword, it's only a modifier
+// when it's the last word of the include.
+func (t *Template) asyncModifier() bool {
+	token := t.nextNonSpace()
+	if token.typ != itemIdentifier || token.val != "async" {
+		t.backup()
+		return false
+	}
+	if t.peekNonSpace().typ != itemRightDelim {
+		t.backup2(token)
+		return false
+	}
+	return true
 }
 
 func (t *Template) parseReturn() Node {
@@ -490,6 +525,9 @@ func (t *Template) itemList(terminatedBy ...NodeType) (list *ListNode, next Node
 				return list, n
 			}
 		}
+		if n.Type() == NodeCase || n.Type() == nodeDefault {
+			t.errorf("unexpected %s outside of switch", n)
+		}
 		list.append(n)
 	}
 	t.errorf("unexpected EOF")
@@ -535,9 +573,25 @@ func (t *Template) action() (n Node) {
 		return t.parseCatch()
 	case itemReturn:
 		return t.parseReturn()
+	case itemSwitch:
+		return t.switchControl()
+	case itemCase:
+		return t.caseControl()
+	case itemDefault:
+		if t.switchDepth > 0 {
+			return t.defaultControl()
+		}
+	case itemBreak, itemContinue:
+		return t.loopControl(token)
+	case itemWith:
+		return t.withControl()
 	}
 
 	t.backup()
+	if token := &t.token[t.peekCount-1]; token.typ == itemDefault {
+		// outside of a switch, {{default}} prints the variable named default
+		token.typ = itemIdentifier
+	}
 	action := t.newAction(t.peek().pos, t.lex.lineNumber())
 
 	expr := t.assignmentOrExpression("command")
@@ -564,7 +618,7 @@ func (t *Template) logicalExpression(context string) (Expression, item) {
 }
 
 func (t *Template) parseExpression(context string) (Expression, item) {
-	expression, endtoken := t.logicalExpression(context)
+	expression, endtoken := t.coalesceExpression(context)
 	if endtoken.typ == itemTernary {
 		var left, right Expression
 		left, endtoken = t.parseExpression(context)
@@ -577,9 +631,18 @@ func (t *Template) parseExpression(context string) (Expression, item) {
 	return expression, endtoken
 }
 
+func (t *Template) coalesceExpression(context string) (Expression, item) {
+	left, endtoken := t.logicalExpression(context)
+	for endtoken.typ == itemCoalesce {
+		right, rightendtoken := t.logicalExpression(context)
+		left, endtoken = t.newCoalesceExpr(left.Position(), t.lex.lineNumber(), left, right, endtoken), rightendtoken
+	}
+	return left, endtoken
+}
+
 func (t *Template) comparativeExpression(context string) (Expression, item) {
 	left, endtoken := t.numericComparativeExpression(context)
-	for endtoken.typ == itemEquals || endtoken.typ == itemNotEquals {
+	for endtoken.typ == itemEquals || endtoken.typ == itemNotEquals || endtoken.typ == itemIn || endtoken.typ == itemMatch {
 		right, rightendtoken := t.numericComparativeExpression(context)
 		left, endtoken = t.newComparativeExpr(left.Position(), t.lex.lineNumber(), left, right, endtoken), rightendtoken
 	}
@@ -621,12 +684,21 @@ func (t *Template) unaryExpression(context string) (Expression, item) {
 		expr, endToken := t.comparativeExpression(context)
 		return t.newNotExpr(expr.Position(), t.lex.lineNumber(), expr), endToken
 	case itemMinus, itemAdd:
-		return t.newAdditiveExpr(next.pos, t.lex.lineNumber(), nil, t.operand("additive expression"), next), t.nextNonSpace()
+		return t.newAdditiveExpr(next.pos, t.lex.lineNumber(), nil, t.operand("additive expression"), next), t.nextOperator()
 	default:
 		t.backup()
 	}
 	operand := t.operand(context)
-	return operand, t.nextNonSpace()
+	return operand, t.nextOperator()
+}
+
+// nextOperator returns the next non-space token following an operand, where the identifier in is the in operator.
+func (t *Template) nextOperator() item {
+	token := t.nextNonSpace()
+	if token.typ == itemIdentifier && token.val == "in" {
+		token.typ = itemIn
+	}
+	return token
 }
 
 func (t *Template) assignmentOrExpression(context string) (operand Expression) {
@@ -687,7 +759,7 @@ func (t *Template) assignmentOrExpression(context string) (operand Expression) {
 			}
 		}
 
-		var isIndexExprGetLookup bool
+		var isIndexExprGetLookup, isMultiValueCall bool
 
 		if context == "range" {
 			if len(left) > 2 || len(right) > 1 {
@@ -697,12 +769,14 @@ func (t *Template) assignmentOrExpression(context string) (operand Expression) {
 			if len(left) != len(right) {
 				if len(left) == 2 && len(right) == 1 && right[0].Type() == NodeIndexExpr {
 					isIndexExprGetLookup = true
+				} else if len(right) == 1 && right[0].Type() == NodeCallExpr {
+					isMultiValueCall = true
 				} else {
 					t.errorf("unexpected number of operands in assign on range")
 				}
 			}
 		}
-		operand = t.newSet(pos, line, isLet, isIndexExprGetLookup, left, right)
+		operand = t.newSet(pos, line, isLet, isIndexExprGetLookup, isMultiValueCall, left, right)
 		return
 
 	}
@@ -788,10 +862,19 @@ func (t *Template) operand(context string) Expression {
 		t.unexpected(t.next(), context, "term")
 	}
 RESET:
-	if t.peek().typ == itemField {
+	if t.peek().typ == itemField || t.peek().typ == itemOptional {
 		chain := t.newChain(t.peek().pos, t.lex.lineNumber(), node)
-		for t.peekNonSpace().typ == itemField {
-			chain.Add(t.next().val)
+	fields:
+		for {
+			switch t.peekNonSpace().typ {
+			case itemField:
+				chain.Add(t.next().val)
+			case itemOptional:
+				t.next()
+				chain.AddOptional(t.expect(itemField, "optional chain", "field").val)
+			default:
+				break fields
+			}
 		}
 		// Compatibility with original API: If the term is of type NodeField
 		// or NodeVariable, just put more fields on the original.
@@ -800,6 +883,10 @@ RESET:
 		// More complex error cases will have to be handled at execution time.
 		switch node.Type() {
 		case NodeField:
+			if chain.Optional != nil {
+				node = chain
+				break
+			}
 			node = t.newField(chain.Position(), t.lex.lineNumber(), chain.String())
 		case NodeBool, NodeString, NodeNumber, NodeNil:
 			t.errorf("unexpected . after term %q", node.String())
@@ -855,6 +942,108 @@ RESET:
 	return node
 }
 
+// mapLiteral parses a map literal, after the '{'.
+func (t *Template) mapLiteral(brace item) *MapLiteralNode {
+	context := "map literal"
+	m := t.newMapLiteral(brace.pos, t.lex.lineNumber())
+	for t.peekNonSpace().typ != itemRightBrace {
+		key, next := t.parseExpression(context)
+		if key == nil {
+			t.unexpected(next, context, "key")
+		}
+		if next.typ != itemColon {
+			t.unexpected(next, context, "colon after key")
+		}
+		if key, ok := key.(*StringNode); ok {
+			for _, k := range m.Keys {
+				if k, ok := k.(*StringNode); ok && k.Text == key.Text {
+					t.errorf("duplicate key %s in %s", key, context)
+				}
+			}
+		}
+		value := t.expression(context, "value")
+		m.Keys, m.Values = append(m.Keys, key), append(m.Values, value)
+		if t.expectOneOf(itemComma, itemRightBrace, context, "comma or closing brace").typ == itemRightBrace {
+			return m
+		}
+	}
+	t.next()
+	return m
+}
+
+// sliceLiteral parses a slice literal, after the '['.
+func (t *Template) sliceLiteral(bracket item) *SliceLiteralNode {
+	context := "slice literal"
+	s := t.newSliceLiteral(bracket.pos, t.lex.lineNumber())
+	for t.peekNonSpace().typ != itemRightBrackets {
+		s.Items = append(s.Items, t.expression(context, "item"))
+		if t.expectOneOf(itemComma, itemRightBrackets, context, "comma or closing bracket").typ == itemRightBrackets {
+			return s
+		}
+	}
+	t.next()
+	return s
+}
+
+// arrow consumes the '=>' of a lambda if it's the next non-space token.
+func (t *Template) arrow() bool {
+	token := t.next()
+	if token.typ == itemSpace {
+		next := t.next()
+		if next.typ == itemArrow {
+			return true
+		}
+		t.backup2(token)
+		return false
+	}
+	if token.typ == itemArrow {
+		return true
+	}
+	t.backup()
+	return false
+}
+
+// lambdaParam checks that expr, parsed as part of a lambda parameter list, is a parameter name.
+func (t *Template) lambdaParam(expr Expression) *IdentifierNode {
+	ident, ok := expr.(*IdentifierNode)
+	if !ok {
+		t.errorf("unexpected %s in lambda parameter list: parameters must be identifiers", expr)
+	}
+	return ident
+}
+
+// lambdaParams parses the rest of a lambda parameter list up to the '=>'; first is the already parsed first parameter,
+// whose trailing comma was consumed.
+func (t *Template) lambdaParams(first Expression) []*IdentifierNode {
+	context := "lambda parameter list"
+	params := []*IdentifierNode{t.lambdaParam(first)}
+	for {
+		token := t.expect(itemIdentifier, context, "identifier")
+		param := t.newIdentifier(token.val, token.pos, t.lex.lineNumber())
+		for _, declared := range params {
+			if declared.Ident == param.Ident {
+				t.errorf("duplicate parameter %s in %s", param, context)
+			}
+		}
+		params = append(params, param)
+		if t.expectOneOf(itemComma, itemRightParen, context, "comma or closing parenthesis").typ == itemRightParen {
+			break
+		}
+	}
+	t.expect(itemArrow, "lambda", "=>")
+	return params
+}
+
+// lambda parses the body of a lambda, after the '=>'.
+func (t *Template) lambda(pos Pos, params []*IdentifierNode) *LambdaExprNode {
+	body, next := t.parseExpression("lambda")
+	if body == nil {
+		t.unexpected(next, "lambda", "expression")
+	}
+	t.backup()
+	return t.newLambdaExpr(pos, t.lex.lineNumber(), params, body)
+}
+
 func (t *Template) parseArguments() (args CallArgs) {
 	context := "call expression argument list"
 	args.Exprs = []Expression{}
@@ -868,6 +1057,31 @@ loop:
 			expr     Expression
 			endtoken item
 		)
+		if peek.typ == itemIdentifier {
+			// name: expression
+			name := t.nextNonSpace()
+			if t.nextNonSpace().typ == itemColon {
+				for _, named := range args.Named {
+					if named.Name == name.val {
+						t.errorf("duplicate argument %s in call", name.val)
+					}
+				}
+				expr, endtoken = t.parseExpression(context)
+				if expr.Type() == NodeUnderscore {
+					t.errorf("pipe slot marker ('_') can't be passed by name")
+				}
+				args.Named = append(args.Named, NamedArg{Name: name.val, Expression: expr})
+				if endtoken.typ != itemComma {
+					t.backup()
+					break loop
+				}
+				continue
+			}
+			t.backup2(name)
+		}
+		if len(args.Named) > 0 {
+			t.errorf("positional argument after named arguments in call")
+		}
 		expr, endtoken = t.parseExpression(context)
 		if expr.Type() == NodeUnderscore {
 			// slot for piped argument
@@ -889,23 +1103,40 @@ loop:
 }
 
 func (t *Template) parseControl(allowElseIf bool, context string) (pos Pos, line int, set *SetNode, expression Expression, list, elseList *ListNode) {
+	pos, line, set, expression = t.parseControlClause(context)
+	t.expectRightDelim(context)
+	list, elseList = t.parseControlBody(allowElseIf, context)
+	return pos, line, set, expression, list, elseList
+}
+
+// parseControlClause parses the assignment and the expression of a control structure, up to the closing delimiter.
+func (t *Template) parseControlClause(context string) (pos Pos, line int, set *SetNode, expression Expression) {
 	line = t.lex.lineNumber()
 
 	expression = t.assignmentOrExpression(context)
 	pos = expression.Position()
 	if expression.Type() == NodeSet {
 		set = expression.(*SetNode)
-		if context != "range" {
+		if context != "range" && context != "with" {
 			t.expect(itemSemicolon, context, "semicolon between assignment and expression")
 			expression = t.expression(context, "expression after assignment")
 		} else {
 			expression = nil
 		}
 	}
+	return pos, line, set, expression
+}
 
-	t.expectRightDelim(context)
+// parseControlBody parses the lists of a control structure after its closing delimiter, up to its {{end}}.
+func (t *Template) parseControlBody(allowElseIf bool, context string) (list, elseList *ListNode) {
 	var next Node
+	if context == "range" {
+		t.loopDepth++
+	}
 	list, next = t.itemList(nodeElse, nodeEnd)
+	if context == "range" {
+		t.loopDepth--
+	}
 	if next.Type() == nodeElse {
 		if allowElseIf && t.peek().typ == itemIf {
 			// Special case for "else if". If the "else" is followed immediately by an "if",
@@ -923,7 +1154,7 @@ func (t *Template) parseControl(allowElseIf bool, context string) (pos Pos, line
 			elseList, _ = t.itemList(nodeEnd)
 		}
 	}
-	return pos, line, set, expression, list, elseList
+	return list, elseList
 }
 
 // If:
@@ -938,12 +1169,170 @@ func (t *Template) ifControl() Node {
 
 // Range:
 //
-//	{{range expression}} itemList {{end}}
-//	{{range expression}} itemList {{else}} itemList {{end}}
+//	{{range expression modifiers}} itemList {{end}}
+//	{{range expression modifiers}} itemList {{else}} itemList {{end}}
+//	{{range from..to [step expression] modifiers}} itemList {{end}}
 //
+// where modifiers are any of where, reverse, offset, limit and batch, in any order.
 // Range keyword is past.
 func (t *Template) rangeControl() Node {
-	return t.newRange(t.parseControl(false, "range"))
+	pos, line, set, expression := t.parseControlClause("range")
+	node := t.newRange(pos, line, set, expression, nil, nil)
+	t.rangeModifiers(node)
+	t.expectRightDelim("range")
+	node.List, node.ElseList = t.parseControlBody(false, "range")
+	return node
+}
+
+// rangeModifiers parses the end and the step of a numeric range and the modifiers following the range expression.
+func (t *Template) rangeModifiers(node *RangeNode) {
+	if t.peekNonSpace().typ == itemDotDot {
+		t.nextNonSpace()
+		if node.Set != nil && len(node.Set.Left) > 1 {
+			t.errorf("numeric range can only assign a single value")
+		}
+		node.To = t.expression("range", "end of numeric range")
+		if token := t.peekNonSpace(); token.typ == itemIdentifier && token.val == "step" {
+			t.nextNonSpace()
+			node.Step = t.expression("range", "step")
+		}
+	}
+	for {
+		token := t.peekNonSpace()
+		if token.typ != itemIdentifier {
+			return
+		}
+		var modifier *Expression
+		switch token.val {
+		case "where":
+			modifier = &node.Where
+		case "reverse":
+			if node.Reverse {
+				t.errorf("duplicate range modifier reverse")
+			}
+			t.nextNonSpace()
+			node.Reverse = true
+			continue
+		case "offset":
+			modifier = &node.Offset
+		case "limit":
+			modifier = &node.Limit
+		case "batch":
+			modifier = &node.Batch
+		default:
+			return
+		}
+		if *modifier != nil {
+			t.errorf("duplicate range modifier %s", token.val)
+		}
+		t.nextNonSpace()
+		*modifier = t.expression("range", token.val+" modifier")
+	}
+}
+
+// With:
+//
+//	{{with expression}} itemList {{end}}
+//	{{with expression}} itemList {{else}} itemList {{end}}
+//	{{with x := expression}} itemList {{else}} itemList {{end}}
+//
+// With keyword is past.
+func (t *Template) withControl() Node {
+	node := t.newWith(t.parseControl(false, "with"))
+	if node.Set != nil && (len(node.Set.Left) != 1 || len(node.Set.Right) != 1) {
+		t.errorf("with can only assign a single value")
+	}
+	return node
+}
+
+// Switch:
+//
+//	{{switch expression}} ({{case expression (, expression)*}} itemList)* {{default}} itemList {{end}}
+//
+// The {{default}} case is optional and may appear anywhere among the other cases. Only whitespace is
+// allowed between {{switch}} and the first case. Switch keyword is past.
+func (t *Template) switchControl() Node {
+	line := t.lex.lineNumber()
+	var set *SetNode
+	expression := t.assignmentOrExpression("switch")
+	pos := expression.Position()
+	if expression.Type() == NodeSet {
+		set = expression.(*SetNode)
+		t.expect(itemSemicolon, "switch", "semicolon between assignment and expression")
+		expression = t.expression("switch", "expression after assignment")
+	}
+	t.expectRightDelim("switch")
+
+	node := t.newSwitch(pos, line, set, expression)
+	t.switchDepth++
+	defer func() { t.switchDepth-- }()
+	list, next := t.itemList(NodeCase, nodeDefault, nodeEnd)
+	if !IsEmptyTree(list) {
+		t.errorf("unexpected %q before the first case of switch", list.String())
+	}
+	for next.Type() != nodeEnd {
+		list, following := t.itemList(NodeCase, nodeDefault, nodeEnd)
+		if c, ok := next.(*CaseNode); ok {
+			c.List = list
+			node.Cases = append(node.Cases, c)
+		} else if node.Default != nil {
+			t.errorf("multiple defaults in switch")
+		} else {
+			node.Default = list
+		}
+		next = following
+	}
+	return node
+}
+
+// Case:
+//
+//	{{case expression (, expression)*}}
+//
+// Case keyword is past.
+func (t *Template) caseControl() Node {
+	line := t.lex.lineNumber()
+	pos := t.peekNonSpace().pos
+	var expressions []Expression
+	for {
+		expr, next := t.parseExpression("case")
+		if expr == nil {
+			t.unexpected(next, "case", "expression")
+		}
+		expressions = append(expressions, expr)
+		if next.typ != itemComma {
+			t.backup()
+			break
+		}
+	}
+	t.expectRightDelim("case")
+	return t.newCase(pos, line, expressions)
+}
+
+// Break or continue:
+//
+//	{{break}}
+//	{{continue}}
+//
+// Only allowed in the body of a range. Keyword is past.
+func (t *Template) loopControl(keyword item) Node {
+	if t.loopDepth == 0 {
+		t.errorf("%s outside of range", keyword.val)
+	}
+	t.expectRightDelim(keyword.val)
+	if keyword.typ == itemBreak {
+		return t.newBreak(keyword.pos, t.lex.lineNumber())
+	}
+	return t.newContinue(keyword.pos, t.lex.lineNumber())
+}
+
+// Default:
+//
+//	{{default}}
+//
+// Default keyword is past.
+func (t *Template) defaultControl() Node {
+	return t.newDefault(t.expectRightDelim("default").pos, t.lex.lineNumber())
 }
 
 // End:
@@ -1039,7 +1428,11 @@ func (t *Template) term() Node {
 	case itemError:
 		t.errorf("%s", token.val)
 	case itemIdentifier:
-		return t.newIdentifier(token.val, token.pos, t.lex.lineNumber())
+		ident := t.newIdentifier(token.val, token.pos, t.lex.lineNumber())
+		if t.arrow() {
+			return t.lambda(token.pos, []*IdentifierNode{ident})
+		}
+		return ident
 	case itemUnderscore:
 		return t.newUnderscore(token.pos, t.lex.lineNumber())
 	case itemNil:
@@ -1055,11 +1448,29 @@ func (t *Template) term() Node {
 		}
 		return number
 	case itemLeftParen:
-		pipe := t.expression("parenthesized expression", "expression")
-		if token := t.next(); token.typ != itemRightParen {
-			t.unexpected(token, "parenthesized expression", "closing parenthesis")
+		if t.peekNonSpace().typ == itemRightParen {
+			t.next()
+			t.expect(itemArrow, "lambda", "=>")
+			return t.lambda(token.pos, nil)
+		}
+		pipe, next := t.parseExpression("parenthesized expression")
+		if pipe == nil {
+			t.unexpected(next, "parenthesized expression", "expression")
+		}
+		if next.typ == itemComma {
+			return t.lambda(token.pos, t.lambdaParams(pipe))
+		}
+		if next.typ != itemRightParen {
+			t.unexpected(next, "parenthesized expression", "closing parenthesis")
+		}
+		if t.arrow() {
+			return t.lambda(token.pos, []*IdentifierNode{t.lambdaParam(pipe)})
 		}
 		return pipe
+	case itemLeftBrace:
+		return t.mapLiteral(token)
+	case itemLeftBrackets:
+		return t.sliceLiteral(token)
 	case itemString, itemRawString:
 		s, err := unquote(token.val)
 		if err != nil {
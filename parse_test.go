@@ -97,10 +97,110 @@ func TestKeywordsDisallowedAsBlockNames(t *testing.T) {
 	p.ExpectError("block_if.jet", `{{ block if() }}bla{{ end }}`, "template: block_if.jet:1: parsing block clause: unexpected keyword 'if' (expected name)")
 }
 
+func TestSwitchStructure(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectError("case_outside_switch.jet", `{{ if true }}{{ case 1 }}{{ end }}`, "template: case_outside_switch.jet:1: unexpected {{case 1}} outside of switch")
+	p.ExpectPrint(`{{ default := 3 }}{{ default }}`, `{{default:=3}}{{default}}`)
+	p.ExpectError("default_in_case.jet", `{{ switch 1 }}{{ case 1 }}{{ if true }}{{ default }}{{ end }}{{ end }}`, "template: default_in_case.jet:1: unexpected {{default}} outside of switch")
+	p.ExpectError("text_before_case.jet", `{{ switch 1 }}text{{ case 1 }}{{ end }}`, "template: text_before_case.jet:1: unexpected \"text\" before the first case of switch")
+	p.ExpectError("multiple_defaults.jet", `{{ switch 1 }}{{ default }}{{ case 1 }}{{ default }}{{ end }}`, "template: multiple_defaults.jet:1: multiple defaults in switch")
+}
+
+func TestLoopControlOutsideRange(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectError("break.jet", `{{ if true }}{{ break }}{{ end }}`, "template: break.jet:1: break outside of range")
+	p.ExpectError("continue.jet", `{{ continue }}`, "template: continue.jet:1: continue outside of range")
+	p.ExpectError("break_in_else.jet", `{{ range items }}{{ else }}{{ break }}{{ end }}`, "template: break_in_else.jet:1: break outside of range")
+	p.ExpectError("break_in_block.jet", `{{ range items }}{{ block b() }}{{ break }}{{ end }}{{ end }}`, "template: break_in_block.jet:1: break outside of range")
+	p.ExpectError("break_in_content.jet", `{{ range items }}{{ yield b() content }}{{ continue }}{{ end }}{{ end }}`, "template: break_in_content.jet:1: continue outside of range")
+	p.ExpectPrintSame(`{{range items}}{{if true}}{{break}}{{end}}{{continue}}{{end}}`)
+}
+
+func TestWithMultipleAssignment(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectError("with_multiple.jet", `{{ with a, b := x, y }}{{ end }}`, "template: with_multiple.jet:1: with can only assign a single value")
+}
+
+func TestParseNamedArguments(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{fn(a, limit: 10, sep: ", ")}}`)
+	p.ExpectPrintSame(`{{fn(limit: a?b:c)}}`)
+	p.ExpectPrint(`{{ x | fn: limit: 1 }}`, `{{x | fn(limit: 1)}}`)
+	p.ExpectPrint(`{{ fn(a ? b : c, x[1:2]) }}`, `{{fn(a?b:c, x[1:2])}}`)
+	p.ExpectError("positional_after_named.jet", `{{ fn(limit: 1, a) }}`, "template: positional_after_named.jet:1: positional argument after named arguments in call")
+	p.ExpectError("duplicate_named.jet", `{{ fn(limit: 1, limit: 2) }}`, "template: duplicate_named.jet:1: duplicate argument limit in call")
+	p.ExpectError("named_pipe_slot.jet", `{{ x | fn(value: _) }}`, "template: named_pipe_slot.jet:1: pipe slot marker ('_') can't be passed by name")
+}
+
+func TestParseLambdas(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{filter(items, x => x.Price > 10)}}`)
+	p.ExpectPrint(`{{ f := (a, b) => a + b }}`, `{{f:=(a, b) => a + b}}`)
+	p.ExpectPrint(`{{ f := () => 1 }}`, `{{f:=() => 1}}`)
+	p.ExpectPrint(`{{ f := (x) => x }}`, `{{f:=x => x}}`)
+	p.ExpectPrint(`{{ sortBy(items, x => x.Name, reverse: true) }}`, `{{sortBy(items, x => x.Name, reverse: true)}}`)
+	p.ExpectError("lambda_param.jet", `{{ f := (a.b) => 1 }}`, "template: lambda_param.jet:1: unexpected a.b in lambda parameter list: parameters must be identifiers")
+	p.ExpectError("lambda_duplicate.jet", `{{ f := (a, a) => 1 }}`, "template: lambda_duplicate.jet:1: duplicate parameter a in lambda parameter list")
+	p.ExpectError("lambda_body.jet", `{{ f := x => }}`, "template: lambda_body.jet:1: parsing lambda: unexpected token '}}' (expected term)")
+}
+
+func TestParseCoalesceAndOptionalChaining(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{a ?? b ?? "c"}}`)
+	p.ExpectPrint(`{{ a ?? b ? c : d }}`, `{{a ?? b?c:d}}`)
+	p.ExpectPrintSame(`{{a?.b.c?.d}}`)
+	p.ExpectPrintSame(`{{.Owner?.Name}}`)
+	p.ExpectPrintSame(`{{fn(a)?.b}}`)
+	p.ExpectError("optional_field.jet", `{{ a?.(b) }}`, "template: optional_field.jet:1: parsing optional chain: unexpected token '.' (expected field)")
+}
+
+func TestParseMembershipAndMatch(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{"admin" in user.Roles}}`)
+	p.ExpectPrint(`{{ name =~ "^a" && x in y }}`, `{{name =~ "^a" && x in y}}`)
+}
+
+func TestParseLiterals(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{{"title": "x", "count": 3}}}`)
+	p.ExpectPrint(`{{ m := {"a": {"b": [1, 2]}} }}`, `{{m:={"a": {"b": [1, 2]}}}}`)
+	p.ExpectPrint(`{{ [ 1, [], {}, ] }}`, `{{[1, [], {}]}}`)
+	p.ExpectPrintSame(`{{fn([a, b], {"k": v})}}`)
+	p.ExpectError("literal_duplicate.jet", `{{ {"a": 1, "a": 2} }}`, `template: literal_duplicate.jet:1: duplicate key "a" in map literal`)
+	p.ExpectError("literal_colon.jet", `{{ {"a" 1} }}`, `template: literal_colon.jet:1: parsing map literal: unexpected token '1' (expected colon after key)`)
+	p.ExpectError("literal_unclosed.jet", `{{ {"a": 1 }}`, `template: literal_unclosed.jet:1: parsing pipeline: unexpected token '}' (expected pipe or right delimiter)`)
+}
+
+func TestParseInterpolatedStrings(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrint("{{ `Hello ${user.Name}, you have ${count} items` }}", `{{"" + "Hello " + user.Name + ", you have " + count + " items"}}`)
+	p.ExpectPrint("{{ `${a}` }}", `{{"" + a}}`)
+	p.ExpectPrint("{{ `$${a} ${ {\"k\": b}.k }` }}", `{{"" + "${a} " + {"k": b}.k}}`)
+	p.ExpectPrint("{{ `<${ `b` }>` }}", "{{\"\" + \"<\" + `b` + \">\"}}")
+	p.ExpectError("interpolation_empty.jet", "{{ `a ${} b` }}", "template: interpolation_empty.jet:1: empty interpolation in raw quoted string")
+	p.ExpectError("interpolation_unterminated.jet", "{{ `a ${b} c }}", "template: interpolation_unterminated.jet:1: unterminated raw quoted string")
+}
+
+func TestParseNumericRangesAndModifiers(t *testing.T) {
+	p := ParserTestCase{T: t}
+	p.ExpectPrintSame(`{{range i:=1..10}}{{i}}{{end}}`)
+	p.ExpectPrint(`{{ range i := a..b * 2 step 2 }}{{ end }}`, `{{range i:=a..b * 2 step 2}}{{end}}`)
+	p.ExpectPrint(`{{ range 1..-3 }}{{ . }}{{ else }}x{{ end }}`, `{{range 1..-3}}{{.}}{{else}}x{{end}}`)
+	p.ExpectPrint(`{{ range _, v := items batch 3 limit n offset page * n reverse where v.Active }}{{ end }}`, `{{range _, v:=items where v.Active reverse offset page * n limit n batch 3}}{{end}}`)
+	p.ExpectPrint(`{{ range limit := items limit limit }}{{ end }}`, `{{range limit:=items limit limit}}{{end}}`)
+	p.ExpectError("range_duplicate.jet", `{{ range items limit 1 limit 2 }}{{ end }}`, "template: range_duplicate.jet:1: duplicate range modifier limit")
+	p.ExpectError("range_duplicate_reverse.jet", `{{ range items reverse reverse }}{{ end }}`, "template: range_duplicate_reverse.jet:1: duplicate range modifier reverse")
+	p.ExpectError("range_two_vars.jet", `{{ range i, v := 1..3 }}{{ end }}`, "template: range_two_vars.jet:1: numeric range can only assign a single value")
+	p.ExpectError("range_unknown.jet", `{{ range items skip 1 }}{{ end }}`, "template: range_unknown.jet:1: parsing range: unexpected token 'skip' (expected closing delimiter)")
+	p.ExpectError("range_missing.jet", `{{ range items limit }}{{ end }}`, "template: range_missing.jet:1: parsing range: unexpected token '}}' (expected term)")
+}
+
 func TestParseTemplateControl(t *testing.T) {
 	p := ParserTestCase{T: t}
 	p.TestPrintFile("if.jet")
 	p.TestPrintFile("range.jet")
+	p.TestPrintFile("switch.jet")
+	p.TestPrintFile("with.jet")
 }
 
 func TestParseTemplateExpressions(t *testing.T) {
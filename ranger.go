@@ -3,6 +3,7 @@ package jet
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"sync"
 )
@@ -45,6 +46,8 @@ func (r *intsRanger) Range() (index, value reflect.Value, end bool) {
 
 func (r *intsRanger) ProvidesIndex() bool { return true }
 
+func (r *intsRanger) last() bool { return r.val+1 == r.to }
+
 func newIntsRanger(from, to int64) *intsRanger {
 	r := &intsRanger{
 		to:  to,
@@ -54,14 +57,60 @@ func newIntsRanger(from, to int64) *intsRanger {
 	return r
 }
 
+// numberRanger produces the integers of a numeric range like 1..10 step 2.
+type numberRanger struct {
+	from, step int64
+	count, i   int64 // number of integers to produce, and produced so far
+}
+
+var _ Ranger = &numberRanger{}
+
+// setup prepares r to produce the integers from from to to (both included) spaced by step, which must be positive.
+// It returns false if there are too many integers to count them using an int64.
+func (r *numberRanger) setup(from, to, step int64) bool {
+	r.from, r.step, r.i = from, step, 0
+	if to < from {
+		r.step = -step
+		from, to = to, from
+	}
+	// to-from overflows int64 when the range spans more than half of the int64 values, but always fits an uint64
+	n := (uint64(to) - uint64(from)) / uint64(step)
+	if n >= math.MaxInt64 {
+		return false
+	}
+	r.count = int64(n) + 1
+	return true
+}
+
+func (r *numberRanger) Range() (_, value reflect.Value, end bool) {
+	if r.i == r.count {
+		end = true
+		return
+	}
+	value = reflect.ValueOf(r.from + r.i*r.step)
+	r.i++
+	return
+}
+
+func (r *numberRanger) ProvidesIndex() bool { return false }
+
+func (r *numberRanger) last() bool { return r.i == r.count }
+
+// reverse makes r produce its integers from the last to the first.
+func (r *numberRanger) reverse() {
+	r.from += (r.count - 1) * r.step
+	r.step = -r.step
+}
+
 type pooledRanger interface {
 	Ranger
 	Setup(reflect.Value)
 }
 
 type sliceRanger struct {
-	v reflect.Value
-	i int
+	v        reflect.Value
+	i        int
+	reversed bool // whether the elements are produced from the last to the first
 }
 
 var _ Ranger = &sliceRanger{}
@@ -70,6 +119,7 @@ var _ pooledRanger = &sliceRanger{}
 func (r *sliceRanger) Setup(v reflect.Value) {
 	r.i = 0
 	r.v = v
+	r.reversed = false
 }
 
 func (r *sliceRanger) Range() (index, value reflect.Value, end bool) {
@@ -77,14 +127,20 @@ func (r *sliceRanger) Range() (index, value reflect.Value, end bool) {
 		end = true
 		return
 	}
-	index = reflect.ValueOf(r.i)
-	value = r.v.Index(r.i)
+	i := r.i
+	if r.reversed {
+		i = r.v.Len() - 1 - r.i
+	}
+	index = reflect.ValueOf(i)
+	value = r.v.Index(i)
 	r.i++
 	return
 }
 
 func (r *sliceRanger) ProvidesIndex() bool { return true }
 
+func (r *sliceRanger) last() bool { return r.i == r.v.Len() }
+
 type mapRanger struct {
 	iter    *reflect.MapIter
 	hasMore bool
@@ -110,6 +166,8 @@ func (r *mapRanger) Range() (key, value reflect.Value, end bool) {
 
 func (r *mapRanger) ProvidesIndex() bool { return true }
 
+func (r *mapRanger) last() bool { return !r.hasMore }
+
 type chanRanger struct {
 	v reflect.Value
 }
@@ -129,9 +187,166 @@ func (r *chanRanger) Range() (_, value reflect.Value, end bool) {
 
 func (r *chanRanger) ProvidesIndex() bool { return false }
 
+// reverseRanger produces the iterations of another ranger from the last to the first, it's used by the reverse
+// modifier of range for the rangers which can't reverse themselves.
+type reverseRanger struct {
+	indexes, values []reflect.Value
+	providesIndex   bool
+}
+
+var _ Ranger = &reverseRanger{}
+
+// reverseOf returns a ranger producing the iterations of r in reverse order.
+func reverseOf(r Ranger) Ranger {
+	switch r := r.(type) {
+	case *numberRanger:
+		r.reverse()
+		return r
+	case *sliceRanger:
+		r.reversed = true
+		return r
+	}
+	reversed := &reverseRanger{providesIndex: r.ProvidesIndex()}
+	for {
+		index, value, end := r.Range()
+		if end {
+			return reversed
+		}
+		reversed.indexes = append(reversed.indexes, index)
+		reversed.values = append(reversed.values, value)
+	}
+}
+
+func (r *reverseRanger) Range() (index, value reflect.Value, end bool) {
+	n := len(r.values)
+	if n == 0 {
+		end = true
+		return
+	}
+	index, value = r.indexes[n-1], r.values[n-1]
+	r.indexes, r.values = r.indexes[:n-1], r.values[:n-1]
+	return
+}
+
+func (r *reverseRanger) ProvidesIndex() bool { return r.providesIndex }
+
+func (r *reverseRanger) last() bool { return len(r.values) == 0 }
+
+// whereRanger produces the iterations of another ranger for which the condition of the where modifier of a range
+// is true.
+type whereRanger struct {
+	Ranger
+	st                     *Runtime
+	node                   *RangeNode
+	keyVarSlot, valVarSlot int
+	scope                  *scope        // scope the range is executed in
+	context                reflect.Value // context the range is executed in
+}
+
+func (r *whereRanger) Range() (index, value reflect.Value, end bool) {
+	// the condition is evaluated in a scope of its own, so that reading loop.last in the body of the range doesn't
+	// change the variables of the current iteration
+	st := r.st
+	scope, context := st.scope, st.context
+	defer func() { st.scope, st.context = scope, context }()
+	for {
+		index, value, end = r.Ranger.Range()
+		if end {
+			return
+		}
+		st.scope, st.context = r.scope, r.context
+		st.newScope(r.node.frame)
+		r.bind(index, value)
+		if isTrue(st.evalPrimaryExpressionGroup(r.node.Where)) {
+			return
+		}
+	}
+}
+
+// bind sets the range variables, or the context, to index and value in the scope of the condition.
+func (r *whereRanger) bind(index, value reflect.Value) {
+	if set := r.node.Set; set != nil {
+		r.bindVariable(set.Left, r.keyVarSlot, index)
+		r.bindVariable(set.Left, r.valVarSlot, value)
+	}
+	if r.valVarSlot < 0 {
+		r.st.context = value
+	}
+}
+
+func (r *whereRanger) bindVariable(left []Expression, slot int, value reflect.Value) {
+	if slot < 0 {
+		return
+	}
+	switch left := left[slot].(type) {
+	case *IdentifierNode:
+		r.st.declareVariable(left, value)
+	case *UnderscoreNode:
+	default:
+		r.st.executeSet(left, value)
+	}
+}
+
+// windowRanger skips the first offset iterations of another ranger and stops after limit iterations, if limit
+// isn't negative.
+type windowRanger struct {
+	Ranger
+	offset, limit int64
+}
+
+func (r *windowRanger) Range() (index, value reflect.Value, end bool) {
+	for ; r.offset > 0; r.offset-- {
+		if _, _, end = r.Ranger.Range(); end {
+			return
+		}
+	}
+	if r.limit == 0 {
+		end = true
+		return
+	}
+	r.limit--
+	return r.Ranger.Range()
+}
+
+// batchRanger groups the values produced by another ranger into slices of size values, the last one can be
+// shorter. The index is the number of the batch, starting at 0, if the other ranger provides indexes.
+type batchRanger struct {
+	ranger Ranger
+	size   int64
+	i      int
+}
+
+var _ Ranger = &batchRanger{}
+
+func (r *batchRanger) Range() (index, value reflect.Value, end bool) {
+	var batch []interface{}
+	for int64(len(batch)) < r.size {
+		_, v, end := r.ranger.Range()
+		if end {
+			break
+		}
+		batch = append(batch, valueInterface(v))
+	}
+	if len(batch) == 0 {
+		end = true
+		return
+	}
+	index, value = reflect.ValueOf(r.i), reflect.ValueOf(batch)
+	r.i++
+	return
+}
+
+func (r *batchRanger) ProvidesIndex() bool { return r.ranger.ProvidesIndex() }
+
 // ranger pooling
 
 var (
+	poolNumberRanger = &sync.Pool{
+		New: func() interface{} {
+			return new(numberRanger)
+		},
+	}
+
 	poolSliceRanger = &sync.Pool{
 		New: func() interface{} {
 			return new(sliceRanger)
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"text/template"
 )
 
@@ -17,8 +18,10 @@ type Set struct {
 	loader          Loader
 	cache           Cache
 	escapee         SafeWriter    // escapee to use at runtime
-	globals         VarMap        // global scope for this template set
-	gmx             *sync.RWMutex // global variables map mutex
+	globals         *atomic.Value // global scope for this template set (a VarMap), replaced on every change
+	gmx             *sync.Mutex   // serializes changes to the global variables
+	formatters      *atomic.Value // formatters used to print values (a *formatterRegistry), replaced on every change
+	fmx             *sync.Mutex   // serializes changes to the formatters
 	extensions      []string
 	developmentMode bool
 	leftDelim       string
@@ -37,11 +40,13 @@ func NewSet(loader Loader, opts ...Option) *Set {
 	}
 
 	s := &Set{
-		loader:  loader,
-		cache:   &cache{},
-		escapee: template.HTMLEscape,
-		globals: VarMap{},
-		gmx:     &sync.RWMutex{},
+		loader:     loader,
+		cache:      &cache{},
+		escapee:    template.HTMLEscape,
+		globals:    &atomic.Value{},
+		gmx:        &sync.Mutex{},
+		formatters: &atomic.Value{},
+		fmx:        &sync.Mutex{},
 		extensions: []string{
 			"", // in case the path is given with the correct extension already
 			".jet",
@@ -50,6 +55,9 @@ func NewSet(loader Loader, opts ...Option) *Set {
 		},
 	}
 
+	s.globals.Store(VarMap{})
+	s.formatters.Store((*formatterRegistry)(nil))
+
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -213,23 +221,35 @@ func (s *Set) Parse(templatePath, contents string) (template *Template, err erro
 
 // AddGlobal adds a global variable into the Set,
 // overriding any value previously set under the specified key.
+// Templates already being executed don't see the change: every execution uses
+// the globals as they were when it started.
 // It returns the Set it was called on to allow for method chaining.
 func (s *Set) AddGlobal(key string, i interface{}) *Set {
 	s.gmx.Lock()
 	defer s.gmx.Unlock()
-	s.globals[key] = reflect.ValueOf(i)
+	// templates being executed keep using the map they started with, so it's copied instead of modified
+	current := s.globalsSnapshot()
+	globals := make(VarMap, len(current)+1)
+	for k, v := range current {
+		globals[k] = v
+	}
+	globals[key] = reflect.ValueOf(i)
+	s.globals.Store(globals)
 	return s
 }
 
 // LookupGlobal returns the global variable previously set under the specified key.
 // It returns the nil interface and false if no variable exists under that key.
 func (s *Set) LookupGlobal(key string) (val interface{}, found bool) {
-	s.gmx.RLock()
-	defer s.gmx.RUnlock()
-	val, found = s.globals[key]
+	val, found = s.globalsSnapshot()[key]
 	return
 }
 
+// globalsSnapshot returns the global variables of the set. The returned map must not be modified.
+func (s *Set) globalsSnapshot() VarMap {
+	return s.globals.Load().(VarMap)
+}
+
 // AddGlobalFunc adds a global function into the Set,
 // overriding any function previously set under the specified key.
 // It returns the Set it was called on to allow for method chaining.
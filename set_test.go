@@ -15,6 +15,7 @@
 package jet
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"testing"
@@ -74,3 +75,58 @@ func TestGetTemplateConcurrency(t *testing.T) {
 		})
 	}
 }
+
+func TestGlobalsSnapshot(t *testing.T) {
+	l := NewInMemLoader()
+	l.Set("globals", `{{ version }}{{ bump() }}{{ version }}`)
+	set := NewSet(l, WithSafeWriter(nil))
+	version := 1
+	set.AddGlobal("version", version)
+	set.AddGlobalFunc("bump", func(a Arguments) reflect.Value {
+		// adding a global in the middle of a render must not change what the render sees
+		version++
+		set.AddGlobal("version", version)
+		return reflect.Value{}
+	})
+
+	tt, err := set.GetTemplate("globals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"11", "22"} {
+		var buf bytes.Buffer
+		if err := tt.Execute(&buf, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("expected %q, got %q", expected, buf.String())
+		}
+	}
+}
+
+func TestGlobalsConcurrency(t *testing.T) {
+	l := NewInMemLoader()
+	l.Set("foo", "{{ greeting }} {{ .Name }}")
+	set := NewSet(l, WithSafeWriter(nil))
+	set.AddGlobal("greeting", "Hi")
+
+	tt, err := set.GetTemplate("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		i := i
+		t.Run(fmt.Sprintf("CC_%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			set.AddGlobal(fmt.Sprintf("global_%d", i), i)
+			var buf bytes.Buffer
+			if err := tt.Execute(&buf, nil, struct{ Name string }{"Bob"}); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "Hi Bob" {
+				t.Errorf("expected %q, got %q", "Hi Bob", buf.String())
+			}
+		})
+	}
+}
@@ -51,6 +51,12 @@ func (vc VisitorContext) Visit(node jet.Node) {
 		vc.visitPipeNode(node)
 	case *jet.RangeNode:
 		vc.visitRangeNode(node)
+	case *jet.WithNode:
+		vc.visitBranchNode(&node.BranchNode)
+	case *jet.SwitchNode:
+		vc.visitSwitchNode(node)
+	case *jet.CaseNode:
+		vc.visitCaseNode(node)
 	case *jet.BlockNode:
 		vc.visitBlockNode(node)
 	case *jet.IncludeNode:
@@ -69,6 +75,12 @@ func (vc VisitorContext) Visit(node jet.Node) {
 		vc.visitNumericComparativeExprNode(node)
 	case *jet.LogicalExprNode:
 		vc.visitLogicalExprNode(node)
+	case *jet.CoalesceExprNode:
+		vc.visitCoalesceExprNode(node)
+	case *jet.MapLiteralNode:
+		vc.visitMapLiteralNode(node)
+	case *jet.SliceLiteralNode:
+		vc.visitSliceLiteralNode(node)
 	case *jet.CallExprNode:
 		vc.visitCallExprNode(node)
 	case *jet.NotExprNode:
@@ -79,6 +91,10 @@ func (vc VisitorContext) Visit(node jet.Node) {
 		vc.visitIndexExprNode(node)
 	case *jet.SliceExprNode:
 		vc.visitSliceExprNode(node)
+	case *jet.LambdaExprNode:
+		vc.visitLambdaExprNode(node)
+	case *jet.BreakNode:
+	case *jet.ContinueNode:
 	case *jet.TextNode:
 	case *jet.IdentifierNode:
 	case *jet.StringNode:
@@ -116,7 +132,46 @@ func (vc VisitorContext) visitBlockNode(blockNode *jet.BlockNode) {
 }
 
 func (vc VisitorContext) visitRangeNode(rangeNode *jet.RangeNode) {
-	vc.visitBranchNode(&rangeNode.BranchNode)
+	if rangeNode.Set != nil {
+		vc.visitNode(rangeNode.Set)
+	}
+
+	if rangeNode.Expression != nil {
+		vc.visitNode(rangeNode.Expression)
+	}
+
+	for _, node := range []jet.Expression{rangeNode.To, rangeNode.Step, rangeNode.Where, rangeNode.Offset, rangeNode.Limit, rangeNode.Batch} {
+		if node != nil {
+			vc.visitNode(node)
+		}
+	}
+
+	vc.visitNode(rangeNode.List)
+	if rangeNode.ElseList != nil {
+		vc.visitNode(rangeNode.ElseList)
+	}
+}
+
+func (vc VisitorContext) visitSwitchNode(switchNode *jet.SwitchNode) {
+	if switchNode.Set != nil {
+		vc.visitNode(switchNode.Set)
+	}
+
+	vc.visitNode(switchNode.Expression)
+	for _, node := range switchNode.Cases {
+		vc.visitNode(node)
+	}
+
+	if switchNode.Default != nil {
+		vc.visitNode(switchNode.Default)
+	}
+}
+
+func (vc VisitorContext) visitCaseNode(caseNode *jet.CaseNode) {
+	for _, node := range caseNode.Expressions {
+		vc.visitNode(node)
+	}
+	vc.visitNode(caseNode.List)
 }
 
 func (vc VisitorContext) visitPipeNode(pipeNode *jet.PipeNode) {
@@ -191,11 +246,32 @@ func (vc VisitorContext) visitLogicalExprNode(logicalExprNode *jet.LogicalExprNo
 	vc.visitNode(logicalExprNode.Right)
 }
 
+func (vc VisitorContext) visitCoalesceExprNode(coalesceExprNode *jet.CoalesceExprNode) {
+	vc.visitNode(coalesceExprNode.Left)
+	vc.visitNode(coalesceExprNode.Right)
+}
+
+func (vc VisitorContext) visitMapLiteralNode(mapLiteralNode *jet.MapLiteralNode) {
+	for i, key := range mapLiteralNode.Keys {
+		vc.visitNode(key)
+		vc.visitNode(mapLiteralNode.Values[i])
+	}
+}
+
+func (vc VisitorContext) visitSliceLiteralNode(sliceLiteralNode *jet.SliceLiteralNode) {
+	for _, node := range sliceLiteralNode.Items {
+		vc.visitNode(node)
+	}
+}
+
 func (vc VisitorContext) visitCallExprNode(callExprNode *jet.CallExprNode) {
 	vc.visitNode(callExprNode.BaseExpr)
 	for _, node := range callExprNode.Exprs {
 		vc.visitNode(node)
 	}
+	for _, named := range callExprNode.Named {
+		vc.visitNode(named.Expression)
+	}
 }
 
 func (vc VisitorContext) visitNotExprNode(notExprNode *jet.NotExprNode) {
@@ -219,11 +295,21 @@ func (vc VisitorContext) visitSliceExprNode(sliceExprNode *jet.SliceExprNode) {
 	vc.visitNode(sliceExprNode.EndIndex)
 }
 
+func (vc VisitorContext) visitLambdaExprNode(lambdaExprNode *jet.LambdaExprNode) {
+	for _, node := range lambdaExprNode.Params {
+		vc.visitNode(node)
+	}
+	vc.visitNode(lambdaExprNode.Body)
+}
+
 func (vc VisitorContext) visitCommandNode(commandNode *jet.CommandNode) {
 	vc.visitNode(commandNode.BaseExpr)
 	for _, node := range commandNode.Exprs {
 		vc.visitNode(node)
 	}
+	for _, named := range commandNode.Named {
+		vc.visitNode(named.Expression)
+	}
 }
 
 func (vc VisitorContext) visitChainNode(chainNode *jet.ChainNode) {
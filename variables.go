@@ -0,0 +1,442 @@
+// Copyright 2016 José Santos <henrique_1609@me.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jet
+
+import "reflect"
+
+// frameLayout lists the variables declared by a node creating a scope (a list with let statements, an if,
+// switch, with or range with a let statement, a catch with an error variable, a block with parameters or a lambda),
+// in slot order.
+// Scopes created for the node keep the values of these variables in a slice instead of a map.
+type frameLayout struct {
+	names []string
+}
+
+func (l *frameLayout) index(name string) int {
+	for i, n := range l.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// variableSlot is the position of a variable in the scope chain, as resolved when parsing the template.
+type variableSlot struct {
+	layout *frameLayout // layout of the declaring scope, nil if the variable has to be looked up by name
+	depth  int          // number of scopes between the reference and the declaring scope
+	index  int          // index of the variable in the declaring scope
+}
+
+// slot holds the value of a variable declared in a template.
+type slot struct {
+	value    reflect.Value
+	declared bool
+}
+
+// lookup returns the value of the variable name, if it's declared in s.
+func (s *scope) lookup(name string) (reflect.Value, bool) {
+	if s.layout != nil {
+		if i := s.layout.index(name); i >= 0 && s.slots[i].declared {
+			return s.slots[i].value, true
+		}
+	}
+	v, ok := s.variables[name]
+	return v, ok
+}
+
+// let declares the variable name in s, using its slot if the layout of s contains it.
+func (s *scope) let(name string, value reflect.Value) {
+	if s.layout != nil {
+		if i := s.layout.index(name); i >= 0 {
+			s.slots[i] = slot{value: value, declared: true}
+			return
+		}
+	}
+	if s.variables == nil {
+		s.variables = make(VarMap)
+	}
+	s.variables[name] = value
+}
+
+// assign changes the value of the variable name, if it's declared in s.
+func (s *scope) assign(name string, value reflect.Value) bool {
+	if s.layout != nil {
+		if i := s.layout.index(name); i >= 0 && s.slots[i].declared {
+			s.slots[i].value = value
+			return true
+		}
+	}
+	if _, ok := s.variables[name]; ok {
+		s.variables[name] = value
+		return true
+	}
+	return false
+}
+
+// vars returns all variables declared in s.
+func (s *scope) vars() VarMap {
+	vars := make(VarMap, len(s.variables))
+	if s.layout != nil {
+		for i, name := range s.layout.names {
+			if s.slots[i].declared {
+				vars[name] = s.slots[i].value
+			}
+		}
+	}
+	for name, value := range s.variables {
+		vars[name] = value
+	}
+	return vars
+}
+
+// slotScope returns the scope holding the variable in slot, or nil if the scope chain at runtime doesn't
+// match what was expected when parsing (e.g. when a block is yielded from Go code without its parameters).
+func (st *Runtime) slotScope(slot variableSlot) *scope {
+	sc := st.scope
+	for i := 0; i < slot.depth && sc != nil; i++ {
+		sc = sc.parent
+	}
+	if sc == nil || sc.layout != slot.layout {
+		return nil
+	}
+	return sc
+}
+
+// resolveVariable returns the value of the variable referenced by node, using the slot resolved when parsing
+// if possible.
+func (st *Runtime) resolveVariable(node *IdentifierNode) (reflect.Value, error) {
+	if node.slot.layout != nil {
+		if sc := st.slotScope(node.slot); sc != nil && sc.slots[node.slot.index].declared {
+			return indirectEface(sc.slots[node.slot.index].value), nil
+		}
+	}
+	return st.resolve(node.Ident)
+}
+
+// assignVariable changes the value of the variable referenced by node.
+func (st *Runtime) assignVariable(node *IdentifierNode, value reflect.Value) error {
+	if node.slot.layout != nil {
+		if sc := st.slotScope(node.slot); sc != nil && sc.slots[node.slot.index].declared {
+			sc.slots[node.slot.index].value = value
+			return nil
+		}
+	}
+	return st.setValue(node.Ident, value)
+}
+
+// declareVariable declares the variable named by node in the current scope.
+func (st *Runtime) declareVariable(node *IdentifierNode, value reflect.Value) {
+	if node.slot.layout != nil && node.slot.layout == st.scope.layout {
+		st.slots[node.slot.index] = slot{value: value, declared: true}
+		return
+	}
+	st.scope.let(node.Ident, value)
+}
+
+// variableResolver assigns slots to the variables declared in a template and resolves references to them.
+// References which can't be resolved when parsing are looked up by name at runtime: globals and variables
+// passed to Execute, but also variables declared outside of a block or included template, since blocks and
+// included templates run in the scope of the template yielding or including them.
+type variableResolver struct {
+	scopes []*frameLayout // scopes existing at runtime at the current position, innermost last; nil where unknown
+}
+
+// resolveVariables assigns slots to the variables declared in t.
+func (t *Template) resolveVariables() {
+	r := &variableResolver{}
+	r.list(t.Root)
+}
+
+func (r *variableResolver) push(layout *frameLayout) {
+	r.scopes = append(r.scopes, layout)
+}
+
+func (r *variableResolver) pop() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *variableResolver) declare(node Expression) {
+	ident, ok := node.(*IdentifierNode)
+	if !ok {
+		return
+	}
+	layout := r.scopes[len(r.scopes)-1]
+	index := layout.index(ident.Ident)
+	if index < 0 {
+		index = len(layout.names)
+		layout.names = append(layout.names, ident.Ident)
+	}
+	ident.slot = variableSlot{layout: layout, index: index}
+}
+
+func (r *variableResolver) lookup(node *IdentifierNode) {
+	for depth := 0; depth < len(r.scopes); depth++ {
+		layout := r.scopes[len(r.scopes)-1-depth]
+		if layout == nil {
+			return
+		}
+		if index := layout.index(node.Ident); index >= 0 {
+			node.slot = variableSlot{layout: layout, depth: depth, index: index}
+			return
+		}
+	}
+}
+
+func (r *variableResolver) list(list *ListNode) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		if action, ok := node.(*ActionNode); ok && action.Set != nil && action.Set.Let {
+			list.frame = &frameLayout{}
+			r.push(list.frame)
+			defer r.pop()
+			break
+		}
+	}
+	for _, node := range list.Nodes {
+		r.node(node)
+	}
+}
+
+func (r *variableResolver) node(node Node) {
+	switch node := node.(type) {
+	case *ActionNode:
+		r.set(node.Set)
+		if node.Pipe != nil {
+			for _, cmd := range node.Pipe.Cmds {
+				r.expr(cmd)
+			}
+		}
+	case *IfNode:
+		if node.Set != nil && node.Set.Let {
+			// the scope is created before evaluating the right side
+			node.frame = &frameLayout{}
+			r.push(node.frame)
+			defer r.pop()
+		}
+		r.set(node.Set)
+		r.expr(node.Expression)
+		r.list(node.List)
+		r.list(node.ElseList)
+	case *WithNode:
+		if node.Set != nil {
+			r.exprs(node.Set.Right)
+			if node.Set.Let {
+				// the scope is created after evaluating the right side
+				node.frame = &frameLayout{}
+				r.push(node.frame)
+				defer r.pop()
+				r.declare(node.Set.Left[0])
+			} else {
+				r.exprs(node.Set.Left)
+			}
+		} else {
+			r.expr(node.Expression)
+		}
+		r.list(node.List)
+		r.list(node.ElseList)
+	case *SwitchNode:
+		if node.Set != nil && node.Set.Let {
+			node.frame = &frameLayout{}
+			r.push(node.frame)
+			defer r.pop()
+		}
+		r.set(node.Set)
+		r.expr(node.Expression)
+		for _, c := range node.Cases {
+			r.exprs(c.Expressions)
+			r.list(c.List)
+		}
+		r.list(node.Default)
+	case *RangeNode:
+		// everything but the where condition is evaluated before declaring the range variables
+		r.expr(node.To)
+		r.expr(node.Step)
+		r.expr(node.Offset)
+		r.expr(node.Limit)
+		r.expr(node.Batch)
+		if node.Set != nil {
+			r.exprs(node.Set.Right)
+			if node.Set.Let {
+				node.frame = &frameLayout{}
+				r.push(node.frame)
+				defer r.pop()
+				for _, left := range node.Set.Left {
+					r.declare(left)
+				}
+			} else {
+				r.exprs(node.Set.Left)
+			}
+		} else {
+			r.expr(node.Expression)
+		}
+		r.where(node)
+		r.list(node.List)
+		r.list(node.ElseList)
+	case *TryNode:
+		r.list(node.List)
+		if node.Catch != nil {
+			if node.Catch.Err != nil {
+				node.Catch.frame = &frameLayout{}
+				r.push(node.Catch.frame)
+				defer r.pop()
+				r.declare(node.Catch.Err)
+			}
+			r.list(node.Catch.List)
+		}
+	case *YieldNode:
+		// the scope holding the block parameters depends on the block being yielded
+		r.push(nil)
+		defer r.pop()
+		r.params(node.Parameters)
+		r.expr(node.Expression)
+		r.list(node.Content)
+	case *BlockNode:
+		// blocks run in the scope of wherever they're yielded
+		outer := r.scopes
+		defer func() { r.scopes = outer }()
+		r.scopes = nil
+		r.params(node.Parameters)
+		if node.Parameters != nil && len(node.Parameters.List) > 0 {
+			node.frame = &frameLayout{}
+			for _, param := range node.Parameters.List {
+				node.frame.names = append(node.frame.names, param.Identifier)
+			}
+			r.push(node.frame)
+		}
+		r.expr(node.Expression)
+		r.list(node.List)
+		r.list(node.Content)
+	case *IncludeNode:
+		r.expr(node.Name)
+		r.expr(node.Context)
+	case *ReturnNode:
+		r.expr(node.Value)
+	}
+}
+
+// where resolves the where condition of node. The condition is evaluated in a scope of its own, holding the range
+// variables, since reading loop.last evaluates it for the next iteration while the body of the current one runs.
+func (r *variableResolver) where(node *RangeNode) {
+	if node.Where == nil {
+		return
+	}
+	if node.Set != nil && !node.Set.Let {
+		// assigned range variables are declared by name in the scope of the condition
+		r.push(nil)
+		defer r.pop()
+	}
+	r.expr(node.Where)
+}
+
+func (r *variableResolver) set(set *SetNode) {
+	if set == nil {
+		return
+	}
+	r.exprs(set.Right)
+	if set.Let {
+		for _, left := range set.Left {
+			r.declare(left)
+		}
+	} else {
+		r.exprs(set.Left)
+	}
+}
+
+func (r *variableResolver) params(params *BlockParameterList) {
+	if params == nil {
+		return
+	}
+	for _, param := range params.List {
+		r.expr(param.Expression)
+	}
+}
+
+func (r *variableResolver) args(args CallArgs) {
+	r.exprs(args.Exprs)
+	for _, named := range args.Named {
+		r.expr(named.Expression)
+	}
+}
+
+func (r *variableResolver) exprs(exprs []Expression) {
+	for _, expr := range exprs {
+		r.expr(expr)
+	}
+}
+
+func (r *variableResolver) expr(node Node) {
+	switch node := node.(type) {
+	case *IdentifierNode:
+		r.lookup(node)
+	case *ChainNode:
+		r.expr(node.Node)
+	case *CommandNode:
+		r.expr(node.BaseExpr)
+		r.args(node.CallArgs)
+	case *CallExprNode:
+		r.expr(node.BaseExpr)
+		r.args(node.CallArgs)
+	case *AdditiveExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *MultiplicativeExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *ComparativeExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *NumericComparativeExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *LogicalExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *CoalesceExprNode:
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *MapLiteralNode:
+		for i, key := range node.Keys {
+			r.expr(key)
+			r.expr(node.Values[i])
+		}
+	case *SliceLiteralNode:
+		r.exprs(node.Items)
+	case *NotExprNode:
+		r.expr(node.Expr)
+	case *TernaryExprNode:
+		r.expr(node.Boolean)
+		r.expr(node.Left)
+		r.expr(node.Right)
+	case *IndexExprNode:
+		r.expr(node.Base)
+		r.expr(node.Index)
+	case *SliceExprNode:
+		r.expr(node.Base)
+		r.expr(node.Index)
+		r.expr(node.EndIndex)
+	case *LambdaExprNode:
+		// the lambda's scope is created in the scope it was evaluated in, when it's called
+		node.frame = &frameLayout{}
+		r.push(node.frame)
+		defer r.pop()
+		for _, param := range node.Params {
+			r.declare(param)
+		}
+		r.expr(node.Body)
+	}
+}